@@ -0,0 +1,254 @@
+package xratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminHandler is an http.Handler for inspecting and managing the keys of
+// policies, e.g. to see who is being limited during an incident and unblock
+// them. It has no authentication of its own and must only be reachable by
+// operators.
+//
+//	GET    /policies/{policy}/keys?prefix=&cursor=&count=  list the keys in the policy's store
+//	DELETE /policies/{policy}/keys?prefix=                 delete every store key starting with prefix
+//	GET    /policies/{policy}/keys/{key}                   the key's count, window and remaining units
+//	DELETE /policies/{policy}/keys/{key}                   delete a store key as listed
+//	POST   /policies/{policy}/keys/{key}/reset             clear every window tracked for the key
+//	PUT    /policies/{policy}/keys/{key}/limit             override the key's limit, e.g. {"limit": 1000, "ttl": "1h"}
+//	DELETE /policies/{policy}/keys/{key}/limit             drop the override
+//
+// Keys are path segments, so keys containing a slash must be escaped. Listing
+// requires the policy's store to be a ScanStore and deleting by prefix a
+// BulkStore; the prefix parameter must be given, if empty, to delete every key
+// of the policy. Listed and deleted keys are store keys without the policy's
+// prefix, which include the rule and level names of policies with several
+// buckets.
+type AdminHandler struct {
+	lookup func(policy string) *RateLimit
+}
+
+// NewAdminHandler manages the RateLimits lookup returns by policy name, e.g.
+// Policies.Policy
+func NewAdminHandler(lookup func(policy string) *RateLimit) *AdminHandler {
+	return &AdminHandler{lookup: lookup}
+}
+
+type adminKeys struct {
+	Keys   []string `json:"keys"`
+	Cursor string   `json:"cursor"`
+}
+
+type adminDeleted struct {
+	Deleted int `json:"deleted"`
+}
+
+type adminKeyState struct {
+	Policy      string         `json:"policy"`
+	Key         string         `json:"key"`
+	Count       int            `json:"count"`
+	Limit       int            `json:"limit"`
+	Remaining   int            `json:"remaining"`
+	WindowStart time.Time      `json:"window_start"`
+	ResetAt     time.Time      `json:"reset_at"`
+	Blocked     bool           `json:"blocked,omitempty"`
+	Override    *LimitOverride `json:"override,omitempty"`
+}
+
+type adminOverride struct {
+	Limit int      `json:"limit"`
+	TTL   Duration `json:"ttl"`
+}
+
+func (ah *AdminHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, s := range segments {
+		unescaped, err := url.PathUnescape(s)
+		if err != nil {
+			writeJSON(rw, http.StatusBadRequest, serverError{Error: err.Error()})
+			return
+		}
+
+		segments[i] = unescaped
+	}
+
+	if len(segments) < 3 || len(segments) > 5 || segments[0] != "policies" || segments[2] != "keys" {
+		writeJSON(rw, http.StatusNotFound, serverError{Error: "not found"})
+		return
+	}
+
+	rl := ah.lookup(segments[1])
+	if rl == nil {
+		writeJSON(rw, http.StatusNotFound, serverError{Error: ErrUnknownPolicy.Error()})
+		return
+	}
+
+	var key, action string
+	if len(segments) > 3 {
+		key = segments[3]
+	}
+
+	if len(segments) > 4 {
+		action = segments[4]
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		ah.list(rw, r, rl)
+	case key == "" && r.Method == http.MethodDelete:
+		ah.deletePrefix(rw, r, rl)
+	case key != "" && action == "" && r.Method == http.MethodGet:
+		ah.state(rw, r, rl, key)
+	case key != "" && action == "" && r.Method == http.MethodDelete:
+		ah.delete(rw, r, rl, key)
+	case action == "reset" && r.Method == http.MethodPost:
+		ah.reset(rw, r, rl, key)
+	case action == "limit" && r.Method == http.MethodPut:
+		ah.override(rw, r, rl, key)
+	case action == "limit" && r.Method == http.MethodDelete:
+		rl.ClearOverride(key)
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(rw, http.StatusNotFound, serverError{Error: "not found"})
+	}
+}
+
+func (ah *AdminHandler) list(rw http.ResponseWriter, r *http.Request, rl *RateLimit) {
+	ss, ok := rl.Store.(ScanStore)
+	if !ok {
+		writeJSON(rw, http.StatusNotImplemented, serverError{Error: ErrNotSupported.Error()})
+		return
+	}
+
+	query := r.URL.Query()
+
+	var count int
+	if c := query.Get("count"); c != "" {
+		var err error
+		if count, err = strconv.Atoi(c); err != nil {
+			writeJSON(rw, http.StatusBadRequest, serverError{Error: "count must be a number"})
+			return
+		}
+	}
+
+	keys, next, err := ss.Scan(r.Context(), rl.storeKey(query.Get("prefix")), query.Get("cursor"), count)
+	if err != nil {
+		writeStoreError(rw, err)
+		return
+	}
+
+	for i, key := range keys {
+		keys[i] = requestKey(strings.TrimPrefix(key, rl.prefixKey("")))
+	}
+
+	if keys == nil {
+		keys = []string{}
+	}
+
+	writeJSON(rw, http.StatusOK, adminKeys{Keys: keys, Cursor: next})
+}
+
+func (ah *AdminHandler) deletePrefix(rw http.ResponseWriter, r *http.Request, rl *RateLimit) {
+	bulk, ok := rl.Store.(BulkStore)
+	if !ok {
+		writeJSON(rw, http.StatusNotImplemented, serverError{Error: ErrNotSupported.Error()})
+		return
+	}
+
+	prefix, ok := r.URL.Query()["prefix"]
+	if !ok {
+		writeJSON(rw, http.StatusBadRequest, serverError{Error: "prefix is required"})
+		return
+	}
+
+	deleted, err := bulk.DeleteByPrefix(r.Context(), rl.storeKey(prefix[0]))
+	if err != nil {
+		writeStoreError(rw, err)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, adminDeleted{Deleted: deleted})
+}
+
+func (ah *AdminHandler) state(rw http.ResponseWriter, r *http.Request, rl *RateLimit, key string) {
+	res, err := rl.Peek(r.Context(), key)
+	if err != nil {
+		writeStoreError(rw, err)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, newAdminKeyState(rl, res))
+}
+
+func (ah *AdminHandler) delete(rw http.ResponseWriter, r *http.Request, rl *RateLimit, key string) {
+	key = rl.storeKey(key)
+	rl.dropLeases([]*bucket{{key: key}})
+
+	err := rl.deleteItem(r.Context(), key)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		writeJSON(rw, http.StatusNotFound, serverError{Error: err.Error()})
+	case err != nil:
+		writeStoreError(rw, err)
+	default:
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (ah *AdminHandler) reset(rw http.ResponseWriter, r *http.Request, rl *RateLimit, key string) {
+	res, err := rl.Reset(r.Context(), key)
+	if err != nil {
+		writeStoreError(rw, err)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, newAdminKeyState(rl, res))
+}
+
+func (ah *AdminHandler) override(rw http.ResponseWriter, r *http.Request, rl *RateLimit, key string) {
+	var o adminOverride
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		writeJSON(rw, http.StatusBadRequest, serverError{Error: err.Error()})
+		return
+	}
+
+	if o.Limit < 0 || o.TTL <= 0 {
+		writeJSON(rw, http.StatusBadRequest, serverError{Error: "limit must not be negative and ttl must be positive"})
+		return
+	}
+
+	if err := rl.OverrideLimit(key, o.Limit, time.Duration(o.TTL)); err != nil {
+		writeJSON(rw, http.StatusConflict, serverError{Error: err.Error()})
+		return
+	}
+
+	ah.state(rw, r, rl, key)
+}
+
+func newAdminKeyState(rl *RateLimit, res *Result) adminKeyState {
+	state := adminKeyState{
+		Policy:      rl.RateLimitConfig.Name,
+		Key:         res.Key,
+		Count:       res.Limit - res.Remaining,
+		Limit:       res.Limit,
+		Remaining:   res.Remaining,
+		WindowStart: res.ResetAt.Add(-res.Window),
+		ResetAt:     res.ResetAt,
+		Blocked:     res.Blocked,
+	}
+
+	if o, ok := rl.Override(res.Key); ok {
+		state.Override = &o
+	}
+
+	return state
+}
+
+func writeStoreError(rw http.ResponseWriter, err error) {
+	writeJSON(rw, http.StatusInternalServerError, serverError{Error: err.Error()})
+}
@@ -0,0 +1,124 @@
+package xratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandler(t *testing.T) {
+	is := require.New(t)
+
+	limits := map[string]*RateLimit{
+		"api":   New(NewMemoryStore(), RateLimitConfig{Name: "api", Duration: time.Minute, Limit: 2}),
+		"rules": New(NewMemoryStore(), RateLimitConfig{Name: "rules", Rules: []Rule{{Duration: time.Second, Limit: 1}}}),
+		"plain": New(failingStore{NewMemoryStore()}, RateLimitConfig{Name: "plain", Duration: time.Minute, Limit: 2}),
+	}
+	admin := NewAdminHandler(func(policy string) *RateLimit {
+		return limits[policy]
+	})
+
+	do := func(method, path, body string, out interface{}) int {
+		rw := httptest.NewRecorder()
+		admin.ServeHTTP(rw, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+		if out != nil {
+			is.NoError(json.Unmarshal(rw.Body.Bytes(), out), rw.Body.String())
+		}
+
+		return rw.Code
+	}
+
+	ctx := context.Background()
+	api := limits["api"]
+
+	for _, key := range []string{"alice", "bob", "bob", "team/ops"} {
+		api.Consume(ctx, key)
+	}
+
+	var keys adminKeys
+	is.Equal(http.StatusOK, do(http.MethodGet, "/policies/api/keys", "", &keys))
+	is.Equal([]string{"alice", "bob", "team/ops"}, keys.Keys)
+	is.Empty(keys.Cursor)
+
+	is.Equal(http.StatusOK, do(http.MethodGet, "/policies/api/keys?prefix=b&count=1", "", &keys))
+	is.Equal([]string{"bob"}, keys.Keys)
+
+	var state adminKeyState
+	is.Equal(http.StatusOK, do(http.MethodGet, "/policies/api/keys/bob", "", &state))
+	is.Equal(2, state.Count)
+	is.Equal(0, state.Remaining)
+	is.Equal(time.Minute, state.ResetAt.Sub(state.WindowStart))
+	is.Nil(state.Override)
+
+	is.Equal(http.StatusOK, do(http.MethodGet, "/policies/api/keys/team%2Fops", "", &state))
+	is.Equal("team/ops", state.Key)
+	is.Equal(1, state.Count)
+
+	// unblock bob for a while
+	is.Equal(http.StatusOK, do(http.MethodPut, "/policies/api/keys/bob/limit", `{"limit": 5, "ttl": "1h"}`, &state))
+	is.Equal(3, state.Remaining)
+	is.Equal(5, state.Override.Limit)
+
+	_, err := api.Consume(ctx, "bob")
+	is.NoError(err)
+
+	is.Equal(http.StatusNoContent, do(http.MethodDelete, "/policies/api/keys/bob/limit", "", nil))
+
+	_, err = api.Consume(ctx, "bob")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+
+	is.Equal(http.StatusOK, do(http.MethodPost, "/policies/api/keys/bob/reset", "", &state))
+	is.Equal(2, state.Remaining)
+
+	is.Equal(http.StatusNoContent, do(http.MethodDelete, "/policies/api/keys/alice", "", nil))
+	is.Equal(http.StatusNotFound, do(http.MethodDelete, "/policies/api/keys/alice", "", nil))
+
+	is.Equal(http.StatusOK, do(http.MethodGet, "/policies/api/keys", "", &keys))
+	is.Equal([]string{"team/ops"}, keys.Keys)
+
+	var deleted adminDeleted
+	is.Equal(http.StatusBadRequest, do(http.MethodDelete, "/policies/api/keys", "", nil))
+	is.Equal(http.StatusOK, do(http.MethodDelete, "/policies/api/keys?prefix=team/", "", &deleted))
+	is.Equal(1, deleted.Deleted)
+	is.Equal(http.StatusNotImplemented, do(http.MethodDelete, "/policies/plain/keys?prefix=", "", nil))
+
+	// errors
+	is.Equal(http.StatusBadRequest, do(http.MethodPut, "/policies/api/keys/bob/limit", `{"limit": 5}`, nil))
+	is.Equal(http.StatusConflict, do(http.MethodPut, "/policies/rules/keys/bob/limit", `{"limit": 5, "ttl": "1m"}`, nil))
+	is.Equal(http.StatusNotImplemented, do(http.MethodGet, "/policies/plain/keys", "", nil))
+	is.Equal(http.StatusInternalServerError, do(http.MethodGet, "/policies/plain/keys/bob", "", nil))
+	is.Equal(http.StatusNotFound, do(http.MethodGet, "/policies/web/keys", "", nil))
+	is.Equal(http.StatusNotFound, do(http.MethodGet, "/policies/api", "", nil))
+	is.Equal(http.StatusNotFound, do(http.MethodPost, "/policies/api/keys/bob", "", nil))
+	is.Equal(http.StatusBadRequest, do(http.MethodGet, "/policies/api/keys?count=all", "", nil))
+}
+
+func TestOverrideLimit(t *testing.T) {
+	is := require.New(t)
+
+	rl := New(NewMemoryStore(), RateLimitConfig{Duration: time.Minute, Limit: 1, Limits: StaticLimits{"alice": 2}})
+	ctx := context.Background()
+
+	is.NoError(rl.OverrideLimit("alice", 3, time.Millisecond*20))
+
+	res, err := rl.Peek(ctx, "alice")
+	is.NoError(err)
+	is.Equal(3, res.Limit)
+
+	// the provider's limit applies again once the override expires
+	time.Sleep(time.Millisecond * 30)
+
+	res, err = rl.Peek(ctx, "alice")
+	is.NoError(err)
+	is.Equal(2, res.Limit)
+
+	_, ok := rl.Override("alice")
+	is.False(ok)
+}
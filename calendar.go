@@ -0,0 +1,279 @@
+package xratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Calendar aligns a rule's windows to wall clock boundaries in a time zone,
+// e.g. midnight or the first of the month, instead of starting them at a
+// key's first request.
+type Calendar interface {
+	// Window returns the boundaries of the window containing t
+	Window(t time.Time) (start, end time.Time)
+	String() string
+}
+
+type period int
+
+const (
+	periodDay period = iota
+	periodWeek
+	periodMonth
+)
+
+type calendar struct {
+	period  period
+	weekday time.Weekday
+	loc     *time.Location
+}
+
+// Daily windows reset at midnight in loc
+func Daily(loc *time.Location) Calendar {
+	return &calendar{period: periodDay, loc: loc}
+}
+
+// Weekly windows reset at midnight on start in loc
+func Weekly(start time.Weekday, loc *time.Location) Calendar {
+	return &calendar{period: periodWeek, weekday: start, loc: loc}
+}
+
+// Monthly windows reset at midnight on the first of the month in loc
+func Monthly(loc *time.Location) Calendar {
+	return &calendar{period: periodMonth, loc: loc}
+}
+
+func (c *calendar) Window(t time.Time) (time.Time, time.Time) {
+	t = t.In(c.loc)
+	y, m, d := t.Date()
+
+	switch c.period {
+	case periodWeek:
+		start := time.Date(y, m, d-(int(t.Weekday()-c.weekday)+7)%7, 0, 0, 0, 0, c.loc)
+		return start, start.AddDate(0, 0, 7)
+	case periodMonth:
+		start := time.Date(y, m, 1, 0, 0, 0, 0, c.loc)
+		return start, start.AddDate(0, 1, 0)
+	}
+
+	start := time.Date(y, m, d, 0, 0, 0, 0, c.loc)
+	return start, start.AddDate(0, 0, 1)
+}
+
+func (c *calendar) String() string {
+	switch c.period {
+	case periodWeek:
+		return fmt.Sprintf("weekly(%s,%s)", c.weekday, c.loc)
+	case periodMonth:
+		return fmt.Sprintf("monthly(%s)", c.loc)
+	}
+
+	return fmt.Sprintf("daily(%s)", c.loc)
+}
+
+// ParseCalendar reads "daily", "weekly", "monthly" or "cron:<spec>"
+func ParseCalendar(spec string, loc *time.Location) (Calendar, error) {
+	switch {
+	case spec == "daily":
+		return Daily(loc), nil
+	case spec == "weekly":
+		return Weekly(time.Monday, loc), nil
+	case spec == "monthly":
+		return Monthly(loc), nil
+	case strings.HasPrefix(spec, "cron:"):
+		return Cron(strings.TrimPrefix(spec, "cron:"), loc)
+	}
+
+	return nil, fmt.Errorf("calendar must be daily, weekly, monthly or cron:<spec>, got %q", spec)
+}
+
+// cronSearchLimit bounds the search for the next or previous firing, so specs
+// that can never fire (e.g. February 30th) fail instead of looping forever
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+type cron struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64 // bit sets of allowed values
+	domRestricted, dowRestricted  bool
+	loc                           *time.Location
+}
+
+// Cron windows reset whenever the standard five field cron spec
+// "minute hour day-of-month month day-of-week" fires in loc. Fields accept *,
+// numbers, ranges, lists and steps, e.g. "0 9 * * 1-5" or "*/15 * * * *".
+func Cron(spec string, loc *time.Location) (Calendar, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q must have 5 fields", spec)
+	}
+
+	c := &cron{spec: spec, loc: loc}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	sets := []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron spec %q: %w", spec, err)
+		}
+
+		*sets[i] = set
+	}
+
+	c.domRestricted = fields[2] != "*"
+	c.dowRestricted = fields[4] != "*"
+
+	if _, err := c.next(time.Now().In(loc)); err != nil {
+		return nil, fmt.Errorf("cron spec %q: %w", spec, err)
+	}
+
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+
+			lo, hi = n, n
+
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+func (c *cron) Window(t time.Time) (time.Time, time.Time) {
+	t = t.In(c.loc)
+
+	end, err := c.next(t)
+	if err != nil {
+		return t, t
+	}
+
+	start, err := c.prev(t)
+	if err != nil {
+		return t, end
+	}
+
+	return start, end
+}
+
+func (c *cron) String() string {
+	return fmt.Sprintf("cron(%s,%s)", c.spec, c.loc)
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// like cron, a restricted day of month and day of week match either
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// next returns the first firing after t
+func (c *cron) next(t time.Time) (time.Time, error) {
+	limit := t.Add(cronSearchLimit)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		var n time.Time
+
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			n = time.Date(y, m+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.dayMatches(t):
+			n = time.Date(y, m, d+1, 0, 0, 0, 0, c.loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			n = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, c.loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			n = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+
+		// a daylight saving change can map the boundary back onto t
+		if !n.After(t) {
+			n = t.Add(time.Minute)
+		}
+
+		t = n
+	}
+
+	return time.Time{}, fmt.Errorf("never fires")
+}
+
+// prev returns the last firing at or before t
+func (c *cron) prev(t time.Time) (time.Time, error) {
+	limit := t.Add(-cronSearchLimit)
+	t = t.Truncate(time.Minute)
+
+	for t.After(limit) {
+		y, m, d := t.Date()
+		var n time.Time
+
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			n = time.Date(y, m, 1, 0, 0, 0, 0, c.loc).Add(-time.Minute)
+		case !c.dayMatches(t):
+			n = time.Date(y, m, d, 0, 0, 0, 0, c.loc).Add(-time.Minute)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			n = time.Date(y, m, d, t.Hour(), 0, 0, 0, c.loc).Add(-time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			n = t.Add(-time.Minute)
+		default:
+			return t, nil
+		}
+
+		if !n.Before(t) {
+			n = t.Add(-time.Minute)
+		}
+
+		t = n
+	}
+
+	return time.Time{}, fmt.Errorf("never fires")
+}
@@ -0,0 +1,90 @@
+package xratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarWindow(t *testing.T) {
+	is := require.New(t)
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	is.NoError(err)
+
+	// 23:30 UTC is already the next day in Berlin
+	now := time.Date(2021, time.March, 31, 23, 30, 0, 0, time.UTC)
+
+	start, end := Daily(berlin).Window(now)
+	is.Equal(time.Date(2021, time.April, 1, 0, 0, 0, 0, berlin), start)
+	is.Equal(time.Date(2021, time.April, 2, 0, 0, 0, 0, berlin), end)
+
+	start, end = Monthly(time.UTC).Window(now)
+	is.Equal(time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC), start)
+	is.Equal(time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC), end)
+
+	// Wednesday
+	start, end = Weekly(time.Monday, time.UTC).Window(now)
+	is.Equal(time.Date(2021, time.March, 29, 0, 0, 0, 0, time.UTC), start)
+	is.Equal(time.Date(2021, time.April, 5, 0, 0, 0, 0, time.UTC), end)
+
+	// the day the clocks go forward is 23 hours long
+	start, end = Daily(berlin).Window(time.Date(2021, time.March, 28, 12, 0, 0, 0, berlin))
+	is.Equal(23*time.Hour, end.Sub(start))
+}
+
+func TestCron(t *testing.T) {
+	is := require.New(t)
+
+	// weekdays at 9:00 and 17:30
+	cal, err := Cron("0,30 9,17 * * 1-5", time.UTC)
+	is.NoError(err)
+
+	friday := time.Date(2021, time.April, 2, 18, 0, 0, 0, time.UTC)
+	start, end := cal.Window(friday)
+	is.Equal(time.Date(2021, time.April, 2, 17, 30, 0, 0, time.UTC), start)
+	is.Equal(time.Date(2021, time.April, 5, 9, 0, 0, 0, time.UTC), end)
+
+	cal, err = Cron("*/15 * * * *", time.UTC)
+	is.NoError(err)
+
+	start, end = cal.Window(time.Date(2021, time.April, 2, 18, 7, 0, 0, time.UTC))
+	is.Equal(time.Date(2021, time.April, 2, 18, 0, 0, 0, time.UTC), start)
+	is.Equal(time.Date(2021, time.April, 2, 18, 15, 0, 0, time.UTC), end)
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "0 0 30 2 *", "a * * * *"} {
+		_, err := Cron(spec, time.UTC)
+		is.Error(err, spec)
+	}
+}
+
+func TestConsumeCalendar(t *testing.T) {
+	is := require.New(t)
+
+	cal := Daily(time.UTC)
+	rl := New(NewMemoryStore(WithTTL(time.Hour*48)), RateLimitConfig{Limit: 2, Calendar: cal})
+	ctx := context.Background()
+
+	start, end := cal.Window(time.Now())
+
+	res, err := rl.Consume(ctx, "calendar-key")
+	is.NoError(err)
+	is.Equal(end, res.ResetAt)
+	is.Equal(end.Sub(start), res.Window)
+
+	_, err = rl.Consume(ctx, "calendar-key")
+	is.NoError(err)
+
+	res, err = rl.Consume(ctx, "calendar-key")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.InDelta(time.Until(end), res.RetryAfter, float64(time.Second))
+
+	// a counter left over from yesterday doesn't count towards today
+	is.NoError(rl.Store.SetItem(ctx, "calendar-key", &RequestLog{Timestamp: start.AddDate(0, 0, -1), Counter: 2}))
+
+	res, err = rl.Consume(ctx, "calendar-key")
+	is.NoError(err)
+	is.Equal(1, res.Remaining)
+}
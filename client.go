@@ -0,0 +1,75 @@
+package xratelimit
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Client calls the gRPC API of a Server
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+// Policy returns a Limiter for the named policy on the server
+func (c *Client) Policy(name string) *RemoteLimit {
+	return &RemoteLimit{client: c, policy: name}
+}
+
+// RemoteLimit is a policy hosted by a Server. It reports rejections and
+// errors like a RateLimit, e.g. ErrRateLimitExceeded along with the result.
+type RemoteLimit struct {
+	client *Client
+	policy string
+}
+
+func (rl *RemoteLimit) Consume(ctx context.Context, key string) (*Result, error) {
+	return rl.ConsumeN(ctx, key, 1)
+}
+
+func (rl *RemoteLimit) ConsumeN(ctx context.Context, key string, n int) (*Result, error) {
+	cost := int64(n)
+
+	res, err := rl.invoke(ctx, "Consume", newGRPCRequest(rl.policy, key, &cost))
+	if err != nil {
+		return nil, err
+	}
+
+	if !res.Allowed {
+		return res, ErrRateLimitExceeded
+	}
+
+	return res, nil
+}
+
+func (rl *RemoteLimit) Peek(ctx context.Context, key string) (*Result, error) {
+	return rl.invoke(ctx, "Peek", newGRPCRequest(rl.policy, key, nil))
+}
+
+func (rl *RemoteLimit) Reset(ctx context.Context, key string) (*Result, error) {
+	return rl.invoke(ctx, "Reset", newGRPCRequest(rl.policy, key, nil))
+}
+
+func (rl *RemoteLimit) invoke(ctx context.Context, method string, req *dynamicpb.Message) (*Result, error) {
+	resp := dynamicpb.NewMessage(grpcResponseType)
+
+	err := rl.client.conn.Invoke(ctx, "/"+grpcServiceName+"/"+method, req, resp)
+
+	switch status.Code(err) {
+	case codes.OK:
+		return grpcResult(resp), nil
+	case codes.NotFound:
+		return nil, ErrUnknownPolicy
+	case codes.InvalidArgument:
+		return nil, ErrInvalidCost
+	}
+
+	return nil, err
+}
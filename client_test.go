@@ -0,0 +1,143 @@
+package xratelimit
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// dialGRPC serves gs in memory and returns a connection to it
+func dialGRPC(t *testing.T, gs *grpc.Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	go gs.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		gs.Stop()
+	})
+
+	return conn
+}
+
+func TestClient(t *testing.T) {
+	is := require.New(t)
+
+	client := NewClient(dialGRPC(t, testServer().GRPCServer()))
+	ctx := context.Background()
+
+	var rl Limiter = client.Policy("api")
+
+	res, err := rl.ConsumeN(ctx, "alice", 2)
+	is.NoError(err)
+	is.True(res.Allowed)
+	is.Equal(3, res.Limit)
+	is.Equal(1, res.Remaining)
+	is.Equal("alice", res.Key)
+	is.Equal("api", res.Policy)
+	is.False(res.ResetAt.IsZero())
+
+	res, err = rl.Peek(ctx, "alice")
+	is.NoError(err)
+	is.Equal(1, res.Remaining)
+
+	_, err = rl.Consume(ctx, "alice")
+	is.NoError(err)
+
+	res, err = rl.Consume(ctx, "alice")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.False(res.Allowed)
+	is.Positive(int64(res.RetryAfter))
+
+	res, err = rl.Consume(ctx, "mallory")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.True(res.Blocked)
+
+	res, err = rl.Reset(ctx, "alice")
+	is.NoError(err)
+	is.Equal(3, res.Remaining)
+
+	// zero cost is sent as such, not as the default of one
+	res, err = rl.ConsumeN(ctx, "alice", 0)
+	is.NoError(err)
+	is.Equal(3, res.Remaining)
+
+	_, err = rl.ConsumeN(ctx, "alice", -1)
+	is.ErrorIs(err, ErrInvalidCost)
+
+	_, err = client.Policy("web").Consume(ctx, "alice")
+	is.ErrorIs(err, ErrUnknownPolicy)
+}
+
+// TestProtoFile checks the messages and service the server uses against
+// proto/ratelimit.proto
+func TestProtoFile(t *testing.T) {
+	is := require.New(t)
+
+	src, err := ioutil.ReadFile("proto/ratelimit.proto")
+	is.NoError(err)
+
+	pkg := regexp.MustCompile(`package ([\w.]+);`).FindSubmatch(src)
+	is.NotNil(pkg)
+	is.Equal(string(pkg[1]), string(rateLimiterFile.Package()))
+
+	messages := regexp.MustCompile(`message (\w+) \{([^}]*)\}`).FindAllSubmatch(src, -1)
+	is.Equal(len(messages), rateLimiterFile.Messages().Len())
+
+	fieldRe := regexp.MustCompile(`(optional )?(\w+) (\w+) = (\d+);`)
+
+	for _, m := range messages {
+		md := rateLimiterFile.Messages().ByName(protoreflect.Name(m[1]))
+		is.NotNil(md, "message %s", m[1])
+
+		fields := fieldRe.FindAllSubmatch(m[2], -1)
+		is.Equal(len(fields), md.Fields().Len(), "fields of %s", m[1])
+
+		for _, f := range fields {
+			fd := md.Fields().ByName(protoreflect.Name(f[3]))
+			is.NotNil(fd, "field %s.%s", m[1], f[3])
+			is.Equal(string(f[2]), fd.Kind().String(), "type of %s.%s", m[1], f[3])
+			is.Equal(string(f[4]), strconv.Itoa(int(fd.Number())), "number of %s.%s", m[1], f[3])
+			is.Equal(len(f[1]) > 0, fd.HasOptionalKeyword(), "presence of %s.%s", m[1], f[3])
+		}
+	}
+
+	methods := regexp.MustCompile(`rpc (\w+)\((\w+)\) returns \((\w+)\);`).FindAllSubmatch(src, -1)
+	service := rateLimiterFile.Services().ByName("RateLimiter")
+	is.Equal(grpcServiceName, string(service.FullName()))
+	is.Equal(len(methods), service.Methods().Len())
+
+	for _, m := range methods {
+		md := service.Methods().ByName(protoreflect.Name(m[1]))
+		is.NotNil(md, "method %s", m[1])
+		is.Equal(string(m[2]), string(md.Input().Name()))
+		is.Equal(string(m[3]), string(md.Output().Name()))
+	}
+
+	// a zero cost is sent, unlike an unset one
+	cost := int64(0)
+	b, err := proto.Marshal(newGRPCRequest("api", "alice", &cost))
+	is.NoError(err)
+
+	req := dynamicpb.NewMessage(grpcRequestType)
+	is.NoError(proto.Unmarshal(b, req))
+	is.True(req.Has(grpcRequestType.Fields().ByName("cost")))
+	is.Equal("alice", req.Get(grpcRequestType.Fields().ByName("key")).String())
+}
@@ -0,0 +1,93 @@
+// Command xratelimit-server hosts the policies of a policy file behind the
+// HTTP JSON and gRPC APIs of xratelimit.Server, for services that can't embed
+// the Go package.
+//
+//	xratelimit-server -config policies.yaml -http :8080 -grpc :9090 -envoy
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	xratelimit "github.com/Mayowa-Ojo/x-ratelimit"
+)
+
+func main() {
+	config := flag.String("config", "policies.yaml", "policy file, yaml or json")
+	httpAddr := flag.String("http", ":8080", "HTTP listen address, empty to disable")
+	grpcAddr := flag.String("grpc", ":9090", "gRPC listen address, empty to disable")
+	adminAddr := flag.String("admin", "", "admin HTTP listen address, disabled when empty, must only be reachable by operators")
+	envoy := flag.Bool("envoy", false, "also serve Envoy's rate limit service on the gRPC address, with policies named after domains")
+	watch := flag.Duration("watch", time.Second*10, "how often the policy file is checked for changes, zero to disable")
+	flag.Parse()
+
+	policies, err := xratelimit.NewPolicies(*config)
+	if err != nil {
+		log.Fatalf("loading policies: %v", err)
+	}
+
+	policies.OnError = func(err error) {
+		log.Printf("reloading policies: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *watch > 0 {
+		go policies.Watch(ctx, *watch)
+	}
+
+	server := xratelimit.NewServer(policies.Policy)
+	errs := make(chan error, 3)
+
+	if *httpAddr != "" {
+		hs := &http.Server{Addr: *httpAddr, Handler: server}
+		defer hs.Shutdown(context.Background())
+
+		go func() {
+			log.Printf("serving HTTP on %s", *httpAddr)
+			errs <- hs.ListenAndServe()
+		}()
+	}
+
+	if *adminAddr != "" {
+		as := &http.Server{Addr: *adminAddr, Handler: xratelimit.NewAdminHandler(policies.Policy)}
+		defer as.Shutdown(context.Background())
+
+		go func() {
+			log.Printf("serving admin API on %s", *adminAddr)
+			errs <- as.ListenAndServe()
+		}()
+	}
+
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatalf("listening on %s: %v", *grpcAddr, err)
+		}
+
+		gs := server.GRPCServer()
+		if *envoy {
+			xratelimit.NewEnvoyServer(policies.Policy).Register(gs)
+		}
+		defer gs.GracefulStop()
+
+		go func() {
+			log.Printf("serving gRPC on %s", *grpcAddr)
+			errs <- gs.Serve(lis)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		log.Printf("server stopped: %v", err)
+	}
+}
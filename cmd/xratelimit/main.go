@@ -0,0 +1,478 @@
+// Command xratelimit inspects and changes the state kept by xratelimit stores,
+// decoding the stored request logs so operators don't have to read them with
+// redis-cli. Keys are store keys, which start with the name of their policy
+// when it has one, given with or without the store's namespace.
+//
+//	xratelimit -redis localhost:6379 list api:user:
+//	xratelimit -badger /var/lib/ratelimit -output json get -limit 100 -window 1m api:user:alice
+//	xratelimit -redis localhost:6379 -namespace edge reset api:user:alice
+//	xratelimit reset -admin http://localhost:8081 -policy api user:alice
+//	xratelimit -redis localhost:6379 delete -prefix api:
+//	xratelimit set-limit -admin http://localhost:8081 -policy api -ttl 1h user:alice 1000
+//
+// reset on a store zeroes the count of the store keys given, which are the
+// windows of a single rule, leaving the key's other rules, hierarchy levels
+// and leases alone. reset -admin clears every window tracked for a key
+// through the admin API of a running server, as RateLimit.Reset does. set-limit
+// always goes through the admin API, since limit overrides are held by the
+// server rather than the store.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	xratelimit "github.com/Mayowa-Ojo/x-ratelimit"
+)
+
+const usage = `usage: xratelimit [-redis addr | -badger path] [-namespace ns] [-output table|json] command [flags] [args]
+
+commands:
+  get [-limit n -window d] key...         show the count and window of keys
+  list [-limit n -window d] [-max n] [prefix]
+                                          list keys starting with prefix
+  reset key...                            set the count of store keys to zero in their window
+  reset -admin url -policy name key...    clear every window of keys through a server's admin API
+  delete [-prefix] key...                 delete keys, or every key starting with the prefixes
+  set-limit -admin url -policy name [-ttl d] key limit
+                                          override a key's limit through a server's admin API
+`
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "xratelimit: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type cli struct {
+	store     xratelimit.Store
+	namespace string
+	out       io.Writer
+	output    string
+}
+
+// entry is the decoded state of a key
+type entry struct {
+	Key         string     `json:"key"`
+	Count       int        `json:"count"`
+	WindowStart time.Time  `json:"window_start"`
+	Remaining   *int       `json:"remaining,omitempty"`
+	ResetAt     *time.Time `json:"reset_at,omitempty"`
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("xratelimit", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+	}
+
+	redisAddr := flags.String("redis", "", "redis address")
+	badgerPath := flags.String("badger", "", "badger directory")
+	namespace := flags.String("namespace", xratelimit.DefaultNamespace, "the store's namespace")
+	output := flags.String("output", "table", "output format, table or json")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+
+	c := &cli{namespace: *namespace, out: out, output: *output}
+	command, args := flags.Arg(0), flags.Args()[1:]
+
+	switch command {
+	case "set-limit":
+		return c.setLimit(ctx, args)
+	case "reset":
+		if *redisAddr == "" && *badgerPath == "" {
+			return c.reset(ctx, args)
+		}
+	}
+
+	switch {
+	case *redisAddr != "" && *badgerPath != "":
+		return errors.New("-redis and -badger are exclusive")
+	case *redisAddr != "":
+		rs := xratelimit.NewRedisStore(xratelimit.WithAddr(*redisAddr), xratelimit.WithNamespaceRedis(*namespace))
+		defer rs.Close()
+		c.store = rs
+	case *badgerPath != "":
+		bs, err := xratelimit.NewBadgerStore(xratelimit.WithPath(*badgerPath), xratelimit.WithNamespaceBadger(*namespace))
+		if err != nil {
+			return err
+		}
+
+		defer bs.Close()
+		c.store = bs
+	default:
+		return errors.New("a store is required, set -redis or -badger")
+	}
+
+	switch command {
+	case "get":
+		return c.get(ctx, args)
+	case "list":
+		return c.list(ctx, args)
+	case "reset":
+		return c.reset(ctx, args)
+	case "delete":
+		return c.delete(ctx, args)
+	}
+
+	return fmt.Errorf("unknown command %q", command)
+}
+
+// key strips the store's namespace from keys copied from e.g. redis-cli
+func (c *cli) key(key string) string {
+	return strings.TrimPrefix(key, c.namespace+":")
+}
+
+// window adds the -limit and -window flags used to work out what is remaining
+func window(flags *flag.FlagSet) func(e *entry) {
+	limit := flags.Int("limit", 0, "the policy's limit, to show remaining units")
+	duration := flags.Duration("window", 0, "the policy's window, to show when it resets")
+
+	return func(e *entry) {
+		if *limit <= 0 || *duration <= 0 {
+			return
+		}
+
+		resetAt := e.WindowStart.Add(*duration)
+
+		remaining := *limit - e.Count
+		if !time.Now().Before(resetAt) {
+			remaining = *limit
+		} else if remaining < 0 {
+			remaining = 0
+		}
+
+		e.Remaining, e.ResetAt = &remaining, &resetAt
+	}
+}
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	fill := window(flags)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return errors.New("get: missing key")
+	}
+
+	var entries []entry
+
+	for _, key := range flags.Args() {
+		key = c.key(key)
+
+		rlog, err := c.store.GetItem(ctx, key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		e := entry{Key: key, Count: rlog.Counter, WindowStart: rlog.Timestamp}
+		fill(&e)
+		entries = append(entries, e)
+	}
+
+	return c.print(entries)
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	fill := window(flags)
+	max := flags.Int("max", 1000, "most keys listed, zero for all")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ss, ok := c.store.(xratelimit.ScanStore)
+	if !ok {
+		return xratelimit.ErrNotSupported
+	}
+
+	prefix := c.key(flags.Arg(0))
+	entries := []entry{}
+	var cursor string
+
+	for {
+		keys, next, err := ss.Scan(ctx, prefix, cursor, xratelimit.ScanCount)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			rlog, err := c.store.GetItem(ctx, key)
+			if errors.Is(err, xratelimit.ErrKeyNotFound) {
+				continue // deleted since the scan
+			}
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+
+			e := entry{Key: key, Count: rlog.Counter, WindowStart: rlog.Timestamp}
+			fill(&e)
+			entries = append(entries, e)
+
+			if *max > 0 && len(entries) == *max {
+				return c.print(entries)
+			}
+		}
+
+		if next == "" {
+			return c.print(entries)
+		}
+
+		cursor = next
+	}
+}
+
+// reset zeroes the count of store keys, or with -admin resets keys through
+// the admin API
+func (c *cli) reset(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("reset", flag.ContinueOnError)
+	admin := flags.String("admin", "", "URL of a server's admin API")
+	policy := flags.String("policy", "", "policy the keys belong to")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return errors.New("reset: missing key")
+	}
+
+	if *admin != "" || *policy != "" {
+		return c.resetAdmin(ctx, *admin, *policy, flags.Args())
+	}
+
+	if c.store == nil {
+		return errors.New("a store is required, set -redis or -badger, or -admin to reset through a server")
+	}
+
+	for _, key := range flags.Args() {
+		key = c.key(key)
+
+		rlog, err := c.store.GetItem(ctx, key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		rlog.Counter = 0
+		if err := c.store.SetItem(ctx, key, rlog); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		fmt.Fprintf(c.out, "reset %s\n", key)
+	}
+
+	return nil
+}
+
+func (c *cli) delete(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	prefix := flags.Bool("prefix", false, "delete every key starting with the arguments")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return errors.New("delete: missing key")
+	}
+
+	for _, key := range flags.Args() {
+		key = c.key(key)
+
+		if !*prefix {
+			if err := c.store.DeleteItem(ctx, key); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+
+			fmt.Fprintf(c.out, "deleted %s\n", key)
+			continue
+		}
+
+		bulk, ok := c.store.(xratelimit.BulkStore)
+		if !ok {
+			return xratelimit.ErrNotSupported
+		}
+
+		n, err := bulk.DeleteByPrefix(ctx, key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		fmt.Fprintf(c.out, "deleted %d keys starting with %s\n", n, key)
+	}
+
+	return nil
+}
+
+// keyLimit is the key state returned by the admin API
+type keyLimit struct {
+	Key       string `json:"key"`
+	Limit     int    `json:"limit"`
+	Remaining int    `json:"remaining"`
+	Override  *struct {
+		Limit   int       `json:"limit"`
+		Expires time.Time `json:"expires"`
+	} `json:"override"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c *cli) setLimit(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("set-limit", flag.ContinueOnError)
+	admin := flags.String("admin", "", "URL of a server's admin API")
+	policy := flags.String("policy", "", "policy the key belongs to")
+	ttl := flags.Duration("ttl", time.Hour, "how long the override lasts")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *admin == "" || *policy == "" || flags.NArg() != 2 {
+		return errors.New("set-limit: -admin, -policy, a key and a limit are required")
+	}
+
+	key := c.key(flags.Arg(0))
+
+	limit, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("set-limit: invalid limit %q", flags.Arg(1))
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"limit": limit, "ttl": ttl.String()})
+	target := fmt.Sprintf("%s/policies/%s/keys/%s/limit", strings.TrimSuffix(*admin, "/"), url.PathEscape(*policy), url.PathEscape(key))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	o, err := c.admin(req)
+	if err != nil {
+		return fmt.Errorf("set-limit: %w", err)
+	}
+
+	if c.output == "json" {
+		return json.NewEncoder(c.out).Encode(o)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tLIMIT\tREMAINING\tEXPIRES")
+	expires := "-"
+	if o.Override != nil {
+		expires = o.Override.Expires.Format(time.RFC3339)
+	}
+
+	fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", o.Key, o.Limit, o.Remaining, expires)
+
+	return tw.Flush()
+}
+
+// resetAdmin clears every window of keys through the admin API
+func (c *cli) resetAdmin(ctx context.Context, admin, policy string, keys []string) error {
+	if admin == "" || policy == "" {
+		return errors.New("reset: -admin and -policy are required together")
+	}
+
+	var states []*keyLimit
+
+	for _, key := range keys {
+		key = c.key(key)
+		target := fmt.Sprintf("%s/policies/%s/keys/%s/reset", strings.TrimSuffix(admin, "/"), url.PathEscape(policy), url.PathEscape(key))
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, nil)
+		if err != nil {
+			return err
+		}
+
+		o, err := c.admin(req)
+		if err != nil {
+			return fmt.Errorf("reset: %s: %w", key, err)
+		}
+
+		states = append(states, o)
+	}
+
+	if c.output == "json" {
+		return json.NewEncoder(c.out).Encode(states)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tLIMIT\tREMAINING")
+
+	for _, o := range states {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", o.Key, o.Limit, o.Remaining)
+	}
+
+	return tw.Flush()
+}
+
+// admin sends req to the admin API and decodes the key state it returns
+func (c *cli) admin(req *http.Request) (*keyLimit, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var o keyLimit
+	if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+		return nil, errors.New(resp.Status)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(o.Error)
+	}
+
+	return &o, nil
+}
+
+func (c *cli) print(entries []entry) error {
+	if c.output == "json" {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(entries)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tCOUNT\tWINDOW START\tREMAINING\tRESET AT")
+
+	for _, e := range entries {
+		remaining, resetAt := "-", "-"
+		if e.Remaining != nil {
+			remaining, resetAt = strconv.Itoa(*e.Remaining), e.ResetAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", e.Key, e.Count, e.WindowStart.Format(time.RFC3339), remaining, resetAt)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	xratelimit "github.com/Mayowa-Ojo/x-ratelimit"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	store := xratelimit.NewRedisStore(xratelimit.WithAddr(mr.Addr()))
+	rl := xratelimit.New(store, xratelimit.RateLimitConfig{Name: "api", Duration: time.Minute, Limit: 10})
+
+	for _, key := range []string{"user:alice", "user:alice", "user:bob", "ip:10.0.0.1"} {
+		_, err := rl.Consume(ctx, key)
+		is.NoError(err)
+	}
+
+	cmd := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(ctx, append([]string{"-redis", mr.Addr()}, args...), &out)
+
+		return out.String(), err
+	}
+
+	// keys start with the policy's name
+	out, err := cmd("list", "api:user:")
+	is.NoError(err)
+	is.Contains(out, "KEY")
+	is.Contains(out, "api:user:alice  2")
+	is.Contains(out, "api:user:bob    1")
+	is.NotContains(out, "ip:")
+
+	out, err = cmd("-output", "json", "get", "-limit", "10", "-window", "1m", "x-ratelimit:api:user:alice")
+	is.NoError(err)
+
+	var entries []entry
+	is.NoError(json.Unmarshal([]byte(out), &entries))
+	is.Len(entries, 1)
+	is.Equal("api:user:alice", entries[0].Key)
+	is.Equal(2, entries[0].Count)
+	is.Equal(8, *entries[0].Remaining)
+
+	_, err = cmd("reset", "api:user:alice")
+	is.NoError(err)
+
+	res, err := rl.Peek(ctx, "user:alice")
+	is.NoError(err)
+	is.Equal(10, res.Remaining)
+
+	out, err = cmd("delete", "api:user:bob")
+	is.NoError(err)
+	is.Contains(out, "deleted api:user:bob")
+
+	_, err = cmd("delete", "api:user:bob")
+	is.ErrorIs(err, xratelimit.ErrKeyNotFound)
+
+	out, err = cmd("delete", "-prefix", "api:ip:")
+	is.NoError(err)
+	is.Contains(out, "deleted 1 keys")
+
+	out, err = cmd("-output", "json", "list")
+	is.NoError(err)
+	is.NoError(json.Unmarshal([]byte(out), &entries))
+	is.Len(entries, 1)
+
+	_, err = cmd("get", "api:user:carol")
+	is.ErrorIs(err, xratelimit.ErrKeyNotFound)
+
+	_, err = cmd("-namespace", "other", "list")
+	is.NoError(err)
+
+	_, err = cmd("rename", "api:user:alice")
+	is.Error(err)
+
+	is.Error(run(ctx, []string{"list"}, &bytes.Buffer{}), "a store is required")
+
+	// set-limit goes through the admin API
+	admin := httptest.NewServer(xratelimit.NewAdminHandler(func(policy string) *xratelimit.RateLimit {
+		if policy == "api" {
+			return rl
+		}
+
+		return nil
+	}))
+	defer admin.Close()
+
+	out, err = cmd("set-limit", "-admin", admin.URL, "-policy", "api", "-ttl", "10m", "user:alice", "100")
+	is.NoError(err)
+	is.Contains(out, "user:alice  100")
+
+	res, err = rl.Peek(ctx, "user:alice")
+	is.NoError(err)
+	is.Equal(100, res.Limit)
+
+	_, err = cmd("set-limit", "-admin", admin.URL, "-policy", "web", "user:alice", "100")
+	is.EqualError(err, "set-limit: "+xratelimit.ErrUnknownPolicy.Error())
+
+	// reset -admin clears every window through the server, without a store
+	remote := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(ctx, args, &out)
+
+		return out.String(), err
+	}
+
+	_, err = rl.Consume(ctx, "user:alice")
+	is.NoError(err)
+
+	out, err = remote("reset", "-admin", admin.URL, "-policy", "api", "x-ratelimit:user:alice")
+	is.NoError(err)
+	is.Contains(out, "user:alice  100    100")
+
+	res, err = rl.Peek(ctx, "user:alice")
+	is.NoError(err)
+	is.Equal(100, res.Remaining)
+
+	_, err = remote("reset", "-admin", admin.URL, "user:alice")
+	is.EqualError(err, "reset: -admin and -policy are required together")
+
+	_, err = remote("reset", "-admin", admin.URL, "-policy", "web", "user:alice")
+	is.EqualError(err, "reset: user:alice: "+xratelimit.ErrUnknownPolicy.Error())
+
+	// a response without an override prints no expiry
+	stub := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(`{"key":"user:alice","limit":100,"remaining":100}`))
+	}))
+	defer stub.Close()
+
+	out, err = remote("set-limit", "-admin", stub.URL, "-policy", "api", "user:alice", "100")
+	is.NoError(err)
+	is.Contains(out, "user:alice  100    100        -")
+}
@@ -1,19 +1,87 @@
 package xratelimit
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"time"
 )
 
 type ErrMiddlewareHandler = func(rw http.ResponseWriter, r *http.Request, e error)
-type RateLimitExceededHandler = func(rw http.ResponseWriter, r *http.Request)
+type RateLimitExceededHandler = func(rw http.ResponseWriter, r *http.Request, res *Result)
+type KeyFunc = func(r *http.Request) (string, error)
+type CostFunc = func(r *http.Request) int // number of units a request consumes
+type CountFunc = func(status int) bool    // whether a response counts against the limit
 
-var ErrRateLimitExceeded = errors.New("client has exceeded rate limit for given period")
+var (
+	ErrRateLimitExceeded = errors.New("client has exceeded rate limit for given period")
+	ErrInvalidCost       = errors.New("request cost must not be negative")
+)
 
 func DefaultErrMiddlewareHandler(rw http.ResponseWriter, r *http.Request, e error) {
 	http.Error(rw, e.Error(), http.StatusInternalServerError)
 }
 
-func DefaultRateLimitExceededHandler(rw http.ResponseWriter, r *http.Request) {
+func DefaultRateLimitExceededHandler(rw http.ResponseWriter, r *http.Request, res *Result) {
 	http.Error(rw, ErrRateLimitExceeded.Error(), http.StatusTooManyRequests)
 }
+
+// route resolves the RateLimit and key function that apply to r
+func route(router *Router, rl *RateLimit, key KeyFunc, r *http.Request) (*RateLimit, KeyFunc) {
+	if router == nil {
+		return rl, key
+	}
+
+	matched, matchedKey := router.resolve(r)
+	if matched == nil {
+		return rl, key
+	}
+
+	if matchedKey != nil {
+		key = matchedKey
+	}
+
+	return matched, key
+}
+
+func requestCost(cost CostFunc, r *http.Request) int {
+	if cost == nil {
+		return 1
+	}
+
+	return cost(r)
+}
+
+// refundResponse gives back the units of a response CountIf doesn't count.
+// Degraded requests are refunded to the local limiter that counted them, or
+// not at all when nothing was counted. The response has been written, so a
+// failed refund is only reported.
+func refundResponse(ctx context.Context, rl *RateLimit, key string, n int, res *Result, consumedAt time.Time) {
+	target := rl
+	if res.Degraded {
+		if rl.Failover.Mode != FailLocal {
+			return
+		}
+
+		target = rl.localLimiter()
+	}
+
+	if _, err := target.refund(ctx, key, n, consumedAt); err != nil {
+		rl.storeError(key, err)
+	}
+}
+
+// statusRecorder captures the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
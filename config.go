@@ -0,0 +1,687 @@
+package xratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	AlgorithmFixedWindow = "fixed-window"
+
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+	StoreBadger = "badger"
+)
+
+// Config is the schema of a policy file. Files ending in .json are decoded as
+// JSON, anything else as YAML.
+//
+//	mode: most-specific
+//	default: global
+//	stores:
+//	  shared: {type: redis, addr: "localhost:6379"}
+//	policies:
+//	  - name: global
+//	    store: shared
+//	    limit: 100
+//	    duration: 1m
+//	  - name: search
+//	    store: shared
+//	    key: header:X-Api-Key
+//	    rules:
+//	      - {limit: 10, duration: 1s}
+//	      - {limit: 1000, duration: 1h}
+//	    match: {methods: [GET], path_prefix: /search}
+type Config struct {
+	Mode     string                 `json:"mode" yaml:"mode"`       // first-match (default) or most-specific
+	Default  string                 `json:"default" yaml:"default"` // policy applied when no route matches
+	Stores   map[string]StoreConfig `json:"stores" yaml:"stores"`
+	Policies []PolicyConfig         `json:"policies" yaml:"policies"`
+}
+
+type StoreConfig struct {
+	Type string `json:"type" yaml:"type"` // memory, redis or badger
+	Addr string `json:"addr" yaml:"addr"` // redis address
+	Path string `json:"path" yaml:"path"` // badger directory
+
+	Namespace string `json:"namespace" yaml:"namespace"` // prefixes the store's keys, x-ratelimit when empty
+
+	// wraps the store in a BreakerStore when any is set
+	Timeout          Duration `json:"timeout" yaml:"timeout"`
+	BreakerThreshold int      `json:"breaker_threshold" yaml:"breaker_threshold"`
+	BreakerCooldown  Duration `json:"breaker_cooldown" yaml:"breaker_cooldown"`
+}
+
+type PolicyConfig struct {
+	Name      string       `json:"name" yaml:"name"`
+	Algorithm string       `json:"algorithm" yaml:"algorithm"` // defaults to fixed-window
+	Store     string       `json:"store" yaml:"store"`         // name in Config.Stores, an in-memory store when empty
+	Limit     int          `json:"limit" yaml:"limit"`
+	Duration  Duration     `json:"duration" yaml:"duration"`
+	Calendar  string       `json:"calendar" yaml:"calendar"`   // daily, weekly, monthly or cron:<spec>, replaces duration
+	TimeZone  string       `json:"time_zone" yaml:"time_zone"` // IANA name for calendar windows, defaults to UTC
+	Rules     []RuleConfig `json:"rules" yaml:"rules"`
+	Key       string       `json:"key" yaml:"key"` // ip (default), header:<name>, query:<name> or cookie:<name>
+	Whitelist []string     `json:"whitelist" yaml:"whitelist"`
+	Blacklist []string     `json:"blacklist" yaml:"blacklist"`
+	Failover  string       `json:"failover" yaml:"failover"` // error (default), open, closed or local
+	Match     *MatchConfig `json:"match" yaml:"match"`       // routes requests to the policy
+}
+
+type RuleConfig struct {
+	Name     string   `json:"name" yaml:"name"`
+	Limit    int      `json:"limit" yaml:"limit"`
+	Duration Duration `json:"duration" yaml:"duration"`
+	Calendar string   `json:"calendar" yaml:"calendar"`
+}
+
+type MatchConfig struct {
+	Methods    []string `json:"methods" yaml:"methods"`
+	Host       string   `json:"host" yaml:"host"`
+	PathPrefix string   `json:"path_prefix" yaml:"path_prefix"`
+	PathGlob   string   `json:"path_glob" yaml:"path_glob"`
+	PathRegexp string   `json:"path_regexp" yaml:"path_regexp"`
+}
+
+// Duration is a time.Duration written as a string such as "1m30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1m\": %w", err)
+	}
+
+	return d.parse(s)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// ConfigError lists every problem found while validating a Config
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid rate limit config: " + strings.Join(e.Problems, "; ")
+}
+
+// ParseConfig decodes and validates a policy file's contents. format is
+// "json" or "yaml".
+func ParseConfig(data []byte, format string) (*Config, error) {
+	var config Config
+
+	switch format {
+	case "json":
+		dec := json.NewDecoder(strings.NewReader(string(data)))
+		dec.DisallowUnknownFields()
+
+		if err := dec.Decode(&config); err != nil {
+			return nil, fmt.Errorf("invalid rate limit config: %w", err)
+		}
+	case "yaml":
+		if err := yaml.UnmarshalStrict(data, &config); err != nil {
+			return nil, fmt.Errorf("invalid rate limit config: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConfig(data, configFormat(path))
+}
+
+func configFormat(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return "json"
+	}
+
+	return "yaml"
+}
+
+func (c *Config) Validate() error {
+	var problems []string
+
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Mode != "" && c.Mode != "first-match" && c.Mode != "most-specific" {
+		addf("mode must be first-match or most-specific, got %q", c.Mode)
+	}
+
+	storeNames := make([]string, 0, len(c.Stores))
+	for name := range c.Stores {
+		storeNames = append(storeNames, name)
+	}
+
+	sort.Strings(storeNames)
+
+	for _, name := range storeNames {
+		sc := c.Stores[name]
+
+		if sc.Timeout < 0 || sc.BreakerThreshold < 0 || sc.BreakerCooldown < 0 {
+			addf("store %q: timeout and breaker settings can't be negative", name)
+		}
+
+		switch sc.Type {
+		case StoreMemory, StoreRedis:
+		case StoreBadger:
+			if sc.Path == "" {
+				addf("store %q: badger stores need a path", name)
+			}
+		default:
+			addf("store %q: type must be memory, redis or badger, got %q", name, sc.Type)
+		}
+	}
+
+	names := make(map[string]bool)
+
+	for i, pc := range c.Policies {
+		policy := fmt.Sprintf("policy %d", i)
+		if pc.Name != "" {
+			policy = fmt.Sprintf("policy %q", pc.Name)
+		}
+
+		if pc.Name == "" {
+			addf("%s: name is required", policy)
+		} else if names[pc.Name] {
+			addf("%s: name is used by more than one policy", policy)
+		} else if strings.Contains(pc.Name, ":") {
+			// names prefix store keys, "a:b" + "c" would be "a" + "b:c"
+			addf("%s: name must not contain ':'", policy)
+		}
+
+		names[pc.Name] = true
+
+		if pc.Algorithm != "" && pc.Algorithm != AlgorithmFixedWindow {
+			addf("%s: unsupported algorithm %q", policy, pc.Algorithm)
+		}
+
+		if _, ok := c.Stores[pc.Store]; pc.Store != "" && !ok {
+			addf("%s: store %q is not defined", policy, pc.Store)
+		}
+
+		loc, err := time.LoadLocation(pc.TimeZone)
+		if err != nil {
+			addf("%s: invalid time_zone: %s", policy, err)
+			loc = time.UTC
+		}
+
+		if len(pc.Rules) == 0 {
+			if pc.Limit <= 0 {
+				addf("%s: limit must be positive", policy)
+			}
+
+			if pc.Calendar != "" {
+				if pc.Duration != 0 {
+					addf("%s: set either duration or calendar, not both", policy)
+				}
+
+				if _, err := ParseCalendar(pc.Calendar, loc); err != nil {
+					addf("%s: %s", policy, err)
+				}
+			} else if pc.Duration <= 0 {
+				addf("%s: duration must be positive", policy)
+			}
+		} else if pc.Limit != 0 || pc.Duration != 0 || pc.Calendar != "" {
+			addf("%s: set either limit and duration or rules, not both", policy)
+		}
+
+		for j, rc := range pc.Rules {
+			if rc.Calendar != "" {
+				if _, err := ParseCalendar(rc.Calendar, loc); err != nil {
+					addf("%s: rule %d: %s", policy, j, err)
+				}
+
+				if rc.Limit <= 0 || rc.Duration != 0 {
+					addf("%s: rule %d needs a positive limit and either duration or calendar", policy, j)
+				}
+			} else if rc.Limit <= 0 || rc.Duration <= 0 {
+				addf("%s: rule %d needs a positive limit and duration", policy, j)
+			}
+		}
+
+		if _, err := keyFunc(pc.Key); err != nil {
+			addf("%s: %s", policy, err)
+		}
+
+		if _, ok := failureModes[pc.Failover]; !ok {
+			addf("%s: failover must be error, open, closed or local, got %q", policy, pc.Failover)
+		}
+
+		if pc.Match != nil && pc.Match.PathRegexp != "" {
+			if _, err := regexp.Compile(pc.Match.PathRegexp); err != nil {
+				addf("%s: invalid path_regexp: %s", policy, err)
+			}
+		}
+	}
+
+	if c.Default != "" && !names[c.Default] {
+		addf("default policy %q is not defined", c.Default)
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+
+	return nil
+}
+
+var failureModes = map[string]FailureMode{
+	"":       FailError,
+	"error":  FailError,
+	"open":   FailOpen,
+	"closed": FailClosed,
+	"local":  FailLocal,
+}
+
+// keyFunc builds the KeyFunc described by spec, nil for the client ip
+func keyFunc(spec string) (KeyFunc, error) {
+	if spec == "" || spec == "ip" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("key must be ip, header:<name>, query:<name> or cookie:<name>, got %q", spec)
+	}
+
+	name := parts[1]
+
+	switch parts[0] {
+	case "header":
+		return func(r *http.Request) (string, error) {
+			return requiredKey(r.Header.Get(name), spec)
+		}, nil
+	case "query":
+		return func(r *http.Request) (string, error) {
+			return requiredKey(r.URL.Query().Get(name), spec)
+		}, nil
+	case "cookie":
+		return func(r *http.Request) (string, error) {
+			c, err := r.Cookie(name)
+			if err != nil {
+				return "", fmt.Errorf("key %s not found in request", spec)
+			}
+
+			return c.Value, nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("key must be ip, header:<name>, query:<name> or cookie:<name>, got %q", spec)
+}
+
+func requiredKey(value, spec string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("key %s not found in request", spec)
+	}
+
+	return value, nil
+}
+
+// Policies holds the RateLimits built from a policy file. Its Router is
+// handed to middlewares and updated in place whenever the file is reloaded.
+type Policies struct {
+	Router  *Router
+	OnError func(err error) // called when a watched reload fails, the previous policies stay active
+	Logging *Logging        // logs watched reloads
+
+	path     string
+	mu       sync.Mutex
+	stores   map[string]Store
+	backends map[string]Store // stores without their BreakerStore
+	sconfig  map[string]StoreConfig
+	local    map[string]Store // in-memory stores of policies without a store
+	limits   map[string]*RateLimit
+	modTime  time.Time
+	size     int64
+}
+
+// NewPolicies loads the policy file at path
+func NewPolicies(path string) (*Policies, error) {
+	p := &Policies{
+		Router: &Router{},
+		path:   path,
+	}
+
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Policy returns the RateLimit built for the named policy
+func (p *Policies) Policy(name string) *RateLimit {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.limits[name]
+}
+
+// Reload reads the policy file again and swaps the new policies in. When the
+// file is invalid the error is returned and the current policies are kept.
+func (p *Policies) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(p.path)
+	if err != nil {
+		return err
+	}
+
+	router, limits, stale, err := p.build(config)
+	if err != nil {
+		return err
+	}
+
+	p.Router.Replace(router)
+	p.limits = limits
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+
+	for _, store := range stale {
+		if err := closeStore(store); err != nil {
+			p.Logging.log(LogReloadError, "closing replaced rate limit store failed", "path", p.path, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// Watch polls the policy file every interval and reloads it when it changes,
+// until ctx is done
+func (p *Policies) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(p.path)
+		if err == nil {
+			p.mu.Lock()
+			changed := !info.ModTime().Equal(p.modTime) || info.Size() != p.size
+			p.mu.Unlock()
+
+			if !changed {
+				continue
+			}
+
+			if err = p.Reload(); err == nil {
+				p.Logging.log(LogReload, "rate limit policies reloaded", "path", p.path)
+			}
+		}
+
+		if err != nil {
+			p.Logging.log(LogReloadError, "rate limit policies reload failed", "path", p.path, "error", err)
+		}
+
+		if err != nil && p.OnError != nil {
+			p.OnError(err)
+		}
+	}
+}
+
+// build creates the RateLimits for config, reusing stores whose settings did
+// not change so counters survive a reload, and the stores of those whose
+// breaker settings alone changed. The default policy becomes a catch-all route
+// after the others. The stores no longer used are returned to be closed once
+// the new policies are swapped in.
+func (p *Policies) build(config *Config) (*Router, map[string]*RateLimit, []Store, error) {
+	stores := make(map[string]Store)
+	backends := make(map[string]Store)
+	claimed := make(map[Store]bool)
+
+	for name, sc := range config.Stores {
+		if store, ok := p.stores[name]; ok && p.sconfig[name] == sc {
+			stores[name], backends[name] = store, p.backends[name]
+			claimed[p.backends[name]] = true
+		}
+	}
+
+	var created []Store
+
+	for name, sc := range config.Stores {
+		if _, ok := stores[name]; ok {
+			continue
+		}
+
+		// a badger directory can't be opened twice, so a store keeps its
+		// backend when only its breaker settings or its name changed
+		backend := p.backend(sc, claimed)
+		if backend == nil {
+			var err error
+			if backend, err = newBackend(sc); err != nil {
+				for _, store := range created {
+					closeStore(store)
+				}
+
+				return nil, nil, nil, fmt.Errorf("store %q: %w", name, err)
+			}
+
+			created = append(created, backend)
+		}
+
+		claimed[backend] = true
+		stores[name], backends[name] = wrapStore(backend, sc), backend
+	}
+
+	var stale []Store
+
+	for _, backend := range p.backends {
+		if !claimed[backend] {
+			stale = append(stale, backend)
+		}
+	}
+
+	router := &Router{}
+	if config.Mode == "most-specific" {
+		router.Mode = MostSpecific
+	}
+
+	local := make(map[string]Store)
+	limits := make(map[string]*RateLimit)
+	var fallback *Route
+
+	for _, pc := range config.Policies {
+		store, ok := stores[pc.Store]
+		if !ok {
+			if store, ok = p.local[pc.Name]; !ok {
+				store = NewMemoryStore()
+			}
+
+			local[pc.Name] = store
+		}
+
+		rl := New(store, RateLimitConfig{
+			Name:      pc.Name,
+			Duration:  time.Duration(pc.Duration),
+			Limit:     pc.Limit,
+			Whitelist: pc.Whitelist,
+			Blacklist: pc.Blacklist,
+			Failover:  Failover{Mode: failureModes[pc.Failover]},
+		})
+
+		// validated before build
+		loc, _ := time.LoadLocation(pc.TimeZone)
+
+		if pc.Calendar != "" {
+			rl.Calendar, _ = ParseCalendar(pc.Calendar, loc)
+		}
+
+		for _, rc := range pc.Rules {
+			rule := Rule{Name: rc.Name, Limit: rc.Limit, Duration: time.Duration(rc.Duration)}
+
+			if rc.Calendar != "" {
+				rule.Calendar, _ = ParseCalendar(rc.Calendar, loc)
+			}
+
+			rl.Rules = append(rl.Rules, rule)
+		}
+
+		// limits overridden during an incident survive the reload
+		if old, ok := p.limits[pc.Name]; ok {
+			rl.takeOverrides(old)
+		}
+
+		limits[pc.Name] = rl
+
+		key, _ := keyFunc(pc.Key)
+		route := Route{RateLimit: rl, Key: key}
+
+		if pc.Match != nil {
+			route.Methods = pc.Match.Methods
+			route.Host = pc.Match.Host
+			route.PathPrefix = pc.Match.PathPrefix
+			route.PathGlob = pc.Match.PathGlob
+
+			if pc.Match.PathRegexp != "" {
+				route.PathRegexp = regexp.MustCompile(pc.Match.PathRegexp)
+			}
+
+			router.Routes = append(router.Routes, route)
+		}
+
+		if pc.Name == config.Default {
+			fallback = &Route{RateLimit: rl, Key: key}
+		}
+	}
+
+	if fallback != nil {
+		router.Routes = append(router.Routes, *fallback)
+	}
+
+	p.stores = stores
+	p.backends = backends
+	p.sconfig = config.Stores
+	p.local = local
+
+	return router, limits, stale, nil
+}
+
+// backend returns an unclaimed backend of the current stores matching sc
+// apart from its breaker settings
+func (p *Policies) backend(sc StoreConfig, claimed map[Store]bool) Store {
+	for name, backend := range p.backends {
+		if !claimed[backend] && p.sconfig[name].backend() == sc.backend() {
+			return backend
+		}
+	}
+
+	return nil
+}
+
+// backend returns sc without the settings of its BreakerStore
+func (sc StoreConfig) backend() StoreConfig {
+	sc.Timeout, sc.BreakerThreshold, sc.BreakerCooldown = 0, 0, 0
+	return sc
+}
+
+// wrapStore wraps store in a BreakerStore when sc has breaker settings
+func wrapStore(store Store, sc StoreConfig) Store {
+	if sc.Timeout == 0 && sc.BreakerThreshold == 0 && sc.BreakerCooldown == 0 {
+		return store
+	}
+
+	var options []OptionBreaker
+
+	if sc.Timeout > 0 {
+		options = append(options, WithTimeout(time.Duration(sc.Timeout)))
+	}
+
+	if sc.BreakerThreshold > 0 {
+		options = append(options, WithThreshold(sc.BreakerThreshold))
+	}
+
+	if sc.BreakerCooldown > 0 {
+		options = append(options, WithCooldown(time.Duration(sc.BreakerCooldown)))
+	}
+
+	return NewBreakerStore(store, options...)
+}
+
+// closeStore closes stores holding connections or files
+func closeStore(store Store) error {
+	if c, ok := store.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func newBackend(sc StoreConfig) (Store, error) {
+	namespace := sc.Namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	switch sc.Type {
+	case StoreMemory:
+		return NewMemoryStore(WithNamespaceMemory(namespace)), nil
+	case StoreRedis:
+		options := []OptionRedis{WithNamespaceRedis(namespace)}
+		if sc.Addr != "" {
+			options = append(options, WithAddr(sc.Addr))
+		}
+
+		return NewRedisStore(options...), nil
+	case StoreBadger:
+		return NewBadgerStore(WithPath(sc.Path), WithNamespaceBadger(namespace))
+	}
+
+	return nil, errors.New("unknown store type")
+}
@@ -0,0 +1,393 @@
+package xratelimit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigYAML = `
+mode: most-specific
+default: global
+stores:
+  local: {type: memory}
+policies:
+  - name: global
+    store: local
+    limit: 3
+    duration: 1m
+  - name: search
+    key: header:X-Api-Key
+    rules:
+      - {name: burst, limit: 1, duration: 1s}
+      - {name: quota, limit: 100, duration: 1h}
+    blacklist: [blocked-key]
+    match: {methods: [GET], path_prefix: /search}
+`
+
+func TestParseConfig(t *testing.T) {
+	is := require.New(t)
+
+	config, err := ParseConfig([]byte(testConfigYAML), "yaml")
+	is.NoError(err)
+	is.Equal("most-specific", config.Mode)
+	is.Len(config.Policies, 2)
+	is.Equal(Duration(time.Minute), config.Policies[0].Duration)
+	is.Equal(Duration(time.Hour), config.Policies[1].Rules[1].Duration)
+
+	json := `{
+		"policies": [
+			{"name": "api", "limit": 10, "duration": "30s", "match": {"path_regexp": "^/api/"}}
+		]
+	}`
+
+	config, err = ParseConfig([]byte(json), "json")
+	is.NoError(err)
+	is.Equal(Duration(time.Second*30), config.Policies[0].Duration)
+	is.Equal("^/api/", config.Policies[0].Match.PathRegexp)
+}
+
+func TestPoliciesCalendar(t *testing.T) {
+	is := require.New(t)
+
+	dir, err := ioutil.TempDir("", "x-ratelimit-config")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policies.yaml")
+	config := `
+policies:
+  - name: daily
+    limit: 1
+    calendar: daily
+    time_zone: America/New_York
+  - name: quota
+    time_zone: Asia/Tokyo
+    rules:
+      - {name: month, limit: 1000, calendar: monthly}
+      - {limit: 10, duration: 1s}
+`
+	is.NoError(ioutil.WriteFile(path, []byte(config), 0644))
+
+	policies, err := NewPolicies(path)
+	is.NoError(err)
+
+	res, err := policies.Policy("daily").Consume(context.Background(), "config-calendar")
+	is.NoError(err)
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	_, end := Daily(newYork).Window(time.Now())
+	is.Equal(end, res.ResetAt)
+
+	rules := policies.Policy("quota").Rules
+	is.Equal("monthly(Asia/Tokyo)", rules[0].Calendar.String())
+	is.Nil(rules[1].Calendar)
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	is := require.New(t)
+
+	invalid := `
+mode: random
+default: missing
+stores:
+  disk: {type: badger}
+policies:
+  - name: api
+    algorithm: leaky-bucket
+    store: redis
+    limit: 0
+    duration: 1m
+    key: body:user
+    failover: retry
+    match: {path_regexp: "("}
+  - name: api
+    limit: 1
+    duration: 1s
+    rules:
+      - {limit: 1, duration: 1s}
+`
+
+	_, err := ParseConfig([]byte(invalid), "yaml")
+	is.Error(err)
+
+	configErr, ok := err.(*ConfigError)
+	is.True(ok)
+	is.Equal([]string{
+		`mode must be first-match or most-specific, got "random"`,
+		`store "disk": badger stores need a path`,
+		`policy "api": unsupported algorithm "leaky-bucket"`,
+		`policy "api": store "redis" is not defined`,
+		`policy "api": limit must be positive`,
+		`policy "api": key must be ip, header:<name>, query:<name> or cookie:<name>, got "body:user"`,
+		`policy "api": failover must be error, open, closed or local, got "retry"`,
+		"policy \"api\": invalid path_regexp: error parsing regexp: missing closing ): `(`",
+		`policy "api": name is used by more than one policy`,
+		`policy "api": set either limit and duration or rules, not both`,
+		`default policy "missing" is not defined`,
+	}, configErr.Problems)
+
+	calendars := `
+policies:
+  - name: daily
+    limit: 1
+    duration: 1h
+    calendar: hourly
+    time_zone: Mars/Olympus
+  - name: quota
+    rules:
+      - {limit: 1, calendar: "cron:0 0 * *"}
+`
+
+	_, err = ParseConfig([]byte(calendars), "yaml")
+	is.Error(err)
+	is.Equal([]string{
+		`policy "daily": invalid time_zone: unknown time zone Mars/Olympus`,
+		`policy "daily": set either duration or calendar, not both`,
+		`policy "daily": calendar must be daily, weekly, monthly or cron:<spec>, got "hourly"`,
+		`policy "quota": rule 0: cron spec "0 0 * *" must have 5 fields`,
+	}, err.(*ConfigError).Problems)
+
+	_, err = ParseConfig([]byte("policies:\n  - {name: \"api:v2\", limit: 1, duration: 1s}\n"), "yaml")
+	is.Error(err)
+	is.Equal([]string{`policy "api:v2": name must not contain ':'`}, err.(*ConfigError).Problems)
+
+	_, err = ParseConfig([]byte("policies:\n  - name: api\n    limt: 1\n"), "yaml")
+	is.Error(err)
+
+	_, err = ParseConfig([]byte(`{"policies": [{"name": "api", "duration": 60}]}`), "json")
+	is.Error(err)
+}
+
+func TestPoliciesReload(t *testing.T) {
+	is := require.New(t)
+
+	dir, err := ioutil.TempDir("", "x-ratelimit-config")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policies.yaml")
+	is.NoError(ioutil.WriteFile(path, []byte(testConfigYAML), 0644))
+
+	policies, err := NewPolicies(path)
+	is.NoError(err)
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	ms := NewMiddlewareStd(nil, WithIpAddressStd("config-test-ip"), WithRouterStd(policies.Router)).Handler(handler)
+
+	serve := func(target, apiKey string) int {
+		request := httptest.NewRequest("GET", target, nil)
+		request.Header.Set("X-Api-Key", apiKey)
+		resp := httptest.NewRecorder()
+		ms.ServeHTTP(resp, request)
+
+		return resp.Code
+	}
+
+	is.Equal(http.StatusOK, serve("/search", "alice"))
+	is.Equal(http.StatusTooManyRequests, serve("/search", "alice"))
+	is.Equal(http.StatusTooManyRequests, serve("/search", "blocked-key"))
+
+	for i := 0; i < 3; i++ {
+		is.Equal(http.StatusOK, serve("/items", ""))
+	}
+
+	is.Equal(http.StatusTooManyRequests, serve("/items", ""))
+
+	// an invalid file is rejected and the previous policies stay active
+	is.NoError(ioutil.WriteFile(path, []byte("policies:\n  - name: global\n    limit: -1\n"), 0644))
+	is.Error(policies.Reload())
+	is.Equal(http.StatusTooManyRequests, serve("/items", ""))
+
+	errs := make(chan error, 1)
+	policies.OnError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go policies.Watch(ctx, time.Millisecond*10)
+
+	updated := `
+stores:
+  local: {type: memory}
+default: global
+policies:
+  - name: global
+    store: local
+    limit: 5
+    duration: 1m
+`
+	is.NoError(ioutil.WriteFile(path, []byte(updated), 0644))
+
+	is.Eventually(func() bool {
+		rl := policies.Policy("global")
+		return rl != nil && rl.Limit == 5
+	}, time.Second, time.Millisecond*10)
+
+	// the store is reused, so the three requests already made still count
+	is.Equal(http.StatusOK, serve("/items", ""))
+	is.Equal(http.StatusOK, serve("/search", "alice"))
+	is.Equal(http.StatusTooManyRequests, serve("/items", ""))
+	is.Nil(policies.Policy("search"))
+}
+
+func TestPoliciesBreaker(t *testing.T) {
+	is := require.New(t)
+
+	dir, err := ioutil.TempDir("", "x-ratelimit-config")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policies.yaml")
+	config := `
+stores:
+  shared: {type: memory, timeout: 50ms, breaker_threshold: 3}
+  plain: {type: memory}
+policies:
+  - {name: api, store: shared, limit: 1, duration: 1s, failover: open}
+  - {name: web, store: plain, limit: 1, duration: 1s}
+`
+	is.NoError(ioutil.WriteFile(path, []byte(config), 0644))
+
+	policies, err := NewPolicies(path)
+	is.NoError(err)
+
+	api := policies.Policy("api")
+	is.IsType(&BreakerStore{}, api.Store)
+	is.Equal(FailOpen, api.Failover.Mode)
+	is.IsType(&MemoryStore{}, policies.Policy("web").Store)
+
+	_, err = ParseConfig([]byte("stores:\n  shared: {type: memory, breaker_threshold: -1}\n"), "yaml")
+	is.Error(err)
+}
+
+func TestPoliciesSharedStore(t *testing.T) {
+	is := require.New(t)
+
+	dir, err := ioutil.TempDir("", "x-ratelimit-config")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policies.yaml")
+	config := `
+stores:
+  shared: {type: memory, namespace: edge}
+policies:
+  - {name: api, store: shared, limit: 1, duration: 1m}
+  - {name: web, store: shared, limit: 1, duration: 1m}
+`
+	is.NoError(ioutil.WriteFile(path, []byte(config), 0644))
+
+	policies, err := NewPolicies(path)
+	is.NoError(err)
+
+	api, web := policies.Policy("api"), policies.Policy("web")
+	is.Same(api.Store, web.Store)
+	is.Equal("edge", api.Store.(*MemoryStore).namespace)
+
+	// the same key is counted separately by each policy
+	_, err = api.Consume(context.Background(), "alice")
+	is.NoError(err)
+
+	_, err = web.Consume(context.Background(), "alice")
+	is.NoError(err)
+
+	keys, _, err := api.Store.(ScanStore).Scan(context.Background(), "", "", 0)
+	is.NoError(err)
+	is.Equal([]string{"api:alice", "web:alice"}, keys)
+}
+
+func TestPoliciesReloadStores(t *testing.T) {
+	is := require.New(t)
+
+	dir, err := ioutil.TempDir("", "x-ratelimit-config")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policies.yaml")
+	badger := filepath.Join(dir, "badger")
+
+	write := func(store string) {
+		config := "stores:\n  shared: " + store + "\npolicies:\n  - {name: api, store: shared, limit: 2, duration: 1m}\n"
+		is.NoError(ioutil.WriteFile(path, []byte(config), 0644))
+	}
+
+	write(`{type: badger, path: "` + badger + `", timeout: 50ms}`)
+
+	policies, err := NewPolicies(path)
+	is.NoError(err)
+
+	_, err = policies.Policy("api").Consume(context.Background(), "alice")
+	is.NoError(err)
+
+	// changing the breaker settings keeps the open badger store and its counts
+	for _, threshold := range []int{3, 4} {
+		write(fmt.Sprintf(`{type: badger, path: "%s", timeout: 50ms, breaker_threshold: %d}`, badger, threshold))
+		is.NoError(policies.Reload())
+
+		api := policies.Policy("api")
+		is.Equal(threshold, api.Store.(*BreakerStore).threshold)
+
+		res, err := api.Peek(context.Background(), "alice")
+		is.NoError(err)
+		is.Equal(1, res.Remaining)
+	}
+
+	// a replaced store is closed, releasing the badger directory
+	write(`{type: memory}`)
+	is.NoError(policies.Reload())
+
+	bs, err := NewBadgerStore(WithPath(badger))
+	is.NoError(err)
+	is.NoError(bs.Close())
+}
+
+func TestPoliciesReloadOverrides(t *testing.T) {
+	is := require.New(t)
+
+	dir, err := ioutil.TempDir("", "x-ratelimit-config")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policies.yaml")
+	is.NoError(ioutil.WriteFile(path, []byte("policies:\n  - {name: api, limit: 1, duration: 1m}\n"), 0644))
+
+	policies, err := NewPolicies(path)
+	is.NoError(err)
+
+	api := policies.Policy("api")
+	is.NoError(api.OverrideLimit("alice", 100, time.Hour))
+	is.NoError(api.OverrideLimit("bob", 100, time.Millisecond))
+	time.Sleep(time.Millisecond * 5)
+
+	is.NoError(ioutil.WriteFile(path, []byte("policies:\n  - {name: api, limit: 2, duration: 1m}\n"), 0644))
+	is.NoError(policies.Reload())
+
+	// unexpired overrides survive the reload
+	reloaded := policies.Policy("api")
+	is.NotSame(api, reloaded)
+
+	res, err := reloaded.Peek(context.Background(), "alice")
+	is.NoError(err)
+	is.Equal(100, res.Limit)
+
+	res, err = reloaded.Peek(context.Background(), "bob")
+	is.NoError(err)
+	is.Equal(2, res.Limit)
+}
@@ -0,0 +1,182 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// DescriptorEntry is one key/value pair of an Envoy rate limit descriptor
+type DescriptorEntry struct {
+	Key   string
+	Value string
+}
+
+// DescriptorFunc maps a descriptor of an Envoy request to the policy limiting
+// it and the key it is counted under. Descriptors mapped to an empty or
+// unknown policy are not limited.
+type DescriptorFunc = func(domain string, entries []DescriptorEntry) (policy, key string)
+
+// DefaultDescriptorFunc limits every descriptor with the policy named after
+// the domain, keyed by its entries, e.g. "remote_address=10.0.0.1" or
+// "generic_key=api,header_match=premium"
+func DefaultDescriptorFunc(domain string, entries []DescriptorEntry) (string, string) {
+	parts := make([]string, len(entries))
+	for i, e := range entries {
+		parts[i] = e.Key + "=" + e.Value
+	}
+
+	return domain, strings.Join(parts, ",")
+}
+
+// EnvoyServer implements Envoy's global rate limit service,
+// envoy.service.ratelimit.v3.RateLimitService. Each descriptor of a request
+// consumes hits_addend units, or one, from the policy it maps to, and the
+// request is over the limit when any descriptor is. The most restrictive
+// descriptor's rate limit headers are returned for Envoy to add to the
+// response.
+//
+// Limit overrides sent in descriptors are ignored, the policies' limits apply.
+type EnvoyServer struct {
+	lookup     func(policy string) *RateLimit
+	descriptor DescriptorFunc
+	headers    HeaderStrategy
+}
+
+type OptionEnvoy func(*EnvoyServer)
+
+// NewEnvoyServer serves the RateLimits lookup returns by policy name, e.g.
+// Policies.Policy
+func NewEnvoyServer(lookup func(policy string) *RateLimit, options ...OptionEnvoy) *EnvoyServer {
+	es := &EnvoyServer{
+		lookup:     lookup,
+		descriptor: DefaultDescriptorFunc,
+		headers:    HeadersXRateLimit,
+	}
+
+	for _, option := range options {
+		option(es)
+	}
+
+	return es
+}
+
+func WithDescriptorEnvoy(descriptor DescriptorFunc) OptionEnvoy {
+	return func(es *EnvoyServer) {
+		es.descriptor = descriptor
+	}
+}
+
+func WithHeadersEnvoy(strategy HeaderStrategy) OptionEnvoy {
+	return func(es *EnvoyServer) {
+		es.headers = strategy
+	}
+}
+
+// Register adds the rate limit service to g, which may be a Server's
+// GRPCServer
+func (es *EnvoyServer) Register(g *grpc.Server) {
+	rlsv3.RegisterRateLimitServiceServer(g, es)
+}
+
+func (es *EnvoyServer) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	cost := int(req.HitsAddend)
+	if cost == 0 {
+		cost = 1
+	}
+
+	resp := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+	var results []*Result
+
+	for _, d := range req.Descriptors {
+		entries := make([]DescriptorEntry, len(d.Entries))
+		for i, e := range d.Entries {
+			entries[i] = DescriptorEntry{Key: e.Key, Value: e.Value}
+		}
+
+		var rl *RateLimit
+
+		policy, key := es.descriptor(req.Domain, entries)
+		if policy != "" {
+			rl = es.lookup(policy)
+		}
+
+		if rl == nil {
+			resp.Statuses = append(resp.Statuses, &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK})
+			continue
+		}
+
+		code := rlsv3.RateLimitResponse_OK
+
+		res, err := rl.ConsumeN(ctx, key, cost)
+		switch {
+		case errors.Is(err, ErrRateLimitExceeded):
+			code = rlsv3.RateLimitResponse_OVER_LIMIT
+			resp.OverallCode = code
+		case err != nil:
+			// Envoy applies its failure_mode_deny setting
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+
+		resp.Statuses = append(resp.Statuses, descriptorStatus(policy, code, res))
+		results = append(results, res)
+	}
+
+	if len(results) > 0 {
+		res := mostRestrictive(results)
+
+		set := func(key, value string) {
+			resp.ResponseHeadersToAdd = append(resp.ResponseHeadersToAdd, &corev3.HeaderValue{Key: key, Value: value})
+		}
+
+		writeHeaders(set, es.headers, res)
+		if !res.Allowed {
+			writeRetryAfter(set, es.headers, res)
+		}
+	}
+
+	return resp, nil
+}
+
+func descriptorStatus(policy string, code rlsv3.RateLimitResponse_Code, res *Result) *rlsv3.RateLimitResponse_DescriptorStatus {
+	remaining := res.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code: code,
+		CurrentLimit: &rlsv3.RateLimitResponse_RateLimit{
+			Name:            policy,
+			RequestsPerUnit: uint32(res.Limit),
+			Unit:            envoyUnit(res.Window),
+		},
+		LimitRemaining:     uint32(remaining),
+		DurationUntilReset: durationpb.New(time.Until(res.ResetAt)),
+	}
+}
+
+// envoyUnit returns the unit matching window, UNKNOWN for windows Envoy has no
+// unit for
+func envoyUnit(window time.Duration) rlsv3.RateLimitResponse_RateLimit_Unit {
+	switch window {
+	case time.Second:
+		return rlsv3.RateLimitResponse_RateLimit_SECOND
+	case time.Minute:
+		return rlsv3.RateLimitResponse_RateLimit_MINUTE
+	case time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_HOUR
+	case time.Hour * 24:
+		return rlsv3.RateLimitResponse_RateLimit_DAY
+	}
+
+	return rlsv3.RateLimitResponse_RateLimit_UNKNOWN
+}
@@ -0,0 +1,118 @@
+package xratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	extv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func descriptor(kv ...string) *extv3.RateLimitDescriptor {
+	d := &extv3.RateLimitDescriptor{}
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, &extv3.RateLimitDescriptor_Entry{Key: kv[i], Value: kv[i+1]})
+	}
+
+	return d
+}
+
+func TestEnvoyServer(t *testing.T) {
+	is := require.New(t)
+
+	limits := map[string]*RateLimit{
+		"edge":  New(NewMemoryStore(), RateLimitConfig{Name: "edge", Duration: time.Minute, Limit: 2}),
+		"burst": New(NewMemoryStore(), RateLimitConfig{Name: "burst", Duration: time.Second * 10, Limit: 5}),
+	}
+	lookup := func(policy string) *RateLimit {
+		return limits[policy]
+	}
+
+	// served on the same grpc server as the RateLimiter service
+	gs := testServer().GRPCServer()
+	NewEnvoyServer(lookup).Register(gs)
+
+	conn := dialGRPC(t, gs)
+	client := rlsv3.NewRateLimitServiceClient(conn)
+	ctx := context.Background()
+
+	_, err := NewClient(conn).Policy("api").Consume(ctx, "alice")
+	is.NoError(err)
+
+	req := &rlsv3.RateLimitRequest{Domain: "edge", Descriptors: []*extv3.RateLimitDescriptor{descriptor("remote_address", "10.0.0.1")}}
+
+	resp, err := client.ShouldRateLimit(ctx, req)
+	is.NoError(err)
+	is.Equal(rlsv3.RateLimitResponse_OK, resp.OverallCode)
+	is.Len(resp.Statuses, 1)
+	is.Equal(uint32(1), resp.Statuses[0].LimitRemaining)
+	is.Equal(uint32(2), resp.Statuses[0].CurrentLimit.RequestsPerUnit)
+	is.Equal(rlsv3.RateLimitResponse_RateLimit_MINUTE, resp.Statuses[0].CurrentLimit.Unit)
+	is.Equal("edge", resp.Statuses[0].CurrentLimit.Name)
+
+	headers := map[string]string{}
+	for _, h := range resp.ResponseHeadersToAdd {
+		headers[h.Key] = h.Value
+	}
+	is.Equal("2", headers[HeaderXRateLimitLimit])
+	is.Equal("1", headers[HeaderXRateLimitRemaining])
+
+	resp, err = client.ShouldRateLimit(ctx, req)
+	is.NoError(err)
+	is.Equal(rlsv3.RateLimitResponse_OK, resp.OverallCode)
+
+	resp, err = client.ShouldRateLimit(ctx, req)
+	is.NoError(err)
+	is.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, resp.OverallCode)
+	is.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, resp.Statuses[0].Code)
+	is.Positive(resp.Statuses[0].DurationUntilReset.AsDuration())
+
+	var retryAfter bool
+	for _, h := range resp.ResponseHeadersToAdd {
+		retryAfter = retryAfter || h.Key == HeaderRetryAfter
+	}
+	is.True(retryAfter)
+
+	// other addresses and domains without a policy are allowed
+	resp, err = client.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*extv3.RateLimitDescriptor{descriptor("remote_address", "10.0.0.2"), descriptor("remote_address", "10.0.0.1")},
+	})
+	is.NoError(err)
+	is.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, resp.OverallCode)
+	is.Equal(rlsv3.RateLimitResponse_OK, resp.Statuses[0].Code)
+	is.Equal(rlsv3.RateLimitResponse_OVER_LIMIT, resp.Statuses[1].Code)
+
+	resp, err = client.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{Domain: "internal", Descriptors: []*extv3.RateLimitDescriptor{descriptor("generic_key", "api")}})
+	is.NoError(err)
+	is.Equal(rlsv3.RateLimitResponse_OK, resp.OverallCode)
+	is.Nil(resp.Statuses[0].CurrentLimit)
+	is.Empty(resp.ResponseHeadersToAdd)
+
+	// descriptors mapped to policies by their entries, with hits_addend as the cost
+	byEntry := func(domain string, entries []DescriptorEntry) (string, string) {
+		return entries[0].Key, entries[0].Value
+	}
+
+	gs = grpc.NewServer()
+	NewEnvoyServer(lookup, WithDescriptorEnvoy(byEntry), WithHeadersEnvoy(HeadersNone)).Register(gs)
+	client = rlsv3.NewRateLimitServiceClient(dialGRPC(t, gs))
+
+	resp, err = client.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{Domain: "edge", HitsAddend: 3, Descriptors: []*extv3.RateLimitDescriptor{descriptor("burst", "alice")}})
+	is.NoError(err)
+	is.Equal(rlsv3.RateLimitResponse_OK, resp.OverallCode)
+	is.Equal(uint32(2), resp.Statuses[0].LimitRemaining)
+	is.Equal(rlsv3.RateLimitResponse_RateLimit_UNKNOWN, resp.Statuses[0].CurrentLimit.Unit)
+	is.Empty(resp.ResponseHeadersToAdd)
+
+	// store errors are left to Envoy's failure mode
+	limits["burst"] = New(failingStore{NewMemoryStore()}, RateLimitConfig{Duration: time.Second, Limit: 5})
+
+	_, err = client.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{Domain: "edge", Descriptors: []*extv3.RateLimitDescriptor{descriptor("burst", "alice")}})
+	is.Equal(codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,199 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// FailureMode decides what Consume does when the store fails
+type FailureMode int
+
+const (
+	FailError  FailureMode = iota // return the store's error, middlewares respond with OnError
+	FailOpen                      // allow the request
+	FailClosed                    // reject the request
+	FailLocal                     // count the request in a local store with scaled limits
+)
+
+// DefaultStoreRetry is how long a failed store is skipped when Failover.Retry
+// is zero
+const DefaultStoreRetry = time.Second * 5
+
+// Failover configures how a RateLimit behaves while its store is failing.
+// With any mode but FailError, a store that fails is skipped for Retry, after
+// which a single request tries it again. Degraded results have Degraded set,
+// and the failures are still reported to the Observer and Logging. Updates
+// that keep conflicting, ErrTxConflict, are returned whatever the mode.
+type Failover struct {
+	Mode  FailureMode
+	Retry time.Duration
+	Store Store   // FailLocal store, a new MemoryStore when nil
+	Scale float64 // FailLocal factor applied to limits, e.g. 1/instances, 1 when zero
+}
+
+func (f Failover) retry() time.Duration {
+	if f.Retry <= 0 {
+		return DefaultStoreRetry
+	}
+
+	return f.Retry
+}
+
+// storeHealth tracks when a failed store may be tried again
+type storeHealth struct {
+	retryAt int64 // unix nanoseconds, zero while the store is healthy
+}
+
+func (h *storeHealth) allow(now time.Time, retry time.Duration) bool {
+	retryAt := atomic.LoadInt64(&h.retryAt)
+	if retryAt == 0 {
+		return true
+	}
+
+	if now.UnixNano() < retryAt {
+		return false
+	}
+
+	// let a single request probe the store, the rest wait for another interval
+	return atomic.CompareAndSwapInt64(&h.retryAt, retryAt, now.Add(retry).UnixNano())
+}
+
+func (h *storeHealth) fail(now time.Time, retry time.Duration) {
+	atomic.StoreInt64(&h.retryAt, now.Add(retry).UnixNano())
+}
+
+func (h *storeHealth) recover() {
+	if atomic.LoadInt64(&h.retryAt) != 0 {
+		atomic.StoreInt64(&h.retryAt, 0)
+	}
+}
+
+// Healthy reports whether the store is used, false while a failed store is
+// being skipped
+func (rl *RateLimit) Healthy() bool {
+	return atomic.LoadInt64(&rl.health.retryAt) == 0
+}
+
+func (rl *RateLimit) storeAvailable(now time.Time) bool {
+	if rl.Failover.Mode == FailError {
+		return true
+	}
+
+	return rl.health.allow(now, rl.Failover.retry())
+}
+
+func (rl *RateLimit) storeRecovered() {
+	if rl.Failover.Mode != FailError {
+		rl.health.recover()
+	}
+}
+
+// storeFailed applies the failure mode to a request whose store call failed
+func (rl *RateLimit) storeFailed(ctx context.Context, key string, n int, buckets []*bucket, now time.Time, err error) (*Result, error) {
+	if rl.Failover.Mode == FailError {
+		return nil, err
+	}
+
+	// conflicts come from contention on a working store, degrading on them
+	// would stop limiting the hottest keys
+	if errors.Is(err, ErrTxConflict) {
+		rl.storeRecovered()
+		return nil, err
+	}
+
+	// a request that was cancelled says nothing about the store
+	if ctx.Err() == nil {
+		rl.health.fail(now, rl.Failover.retry())
+	}
+
+	return rl.degraded(ctx, key, n, buckets, now)
+}
+
+func (rl *RateLimit) degraded(ctx context.Context, key string, n int, buckets []*bucket, now time.Time) (*Result, error) {
+	switch rl.Failover.Mode {
+	case FailOpen:
+		res := rl.fullResult(buckets, key, now)
+		res.Degraded = true
+
+		return res, nil
+	case FailClosed:
+		res := rl.fullResult(buckets, key, now)
+		res.Allowed = false
+		res.Remaining = 0
+		res.RetryAfter = rl.Failover.retry()
+		res.Degraded = true
+
+		return res, ErrRateLimitExceeded
+	}
+
+	res, err := rl.localLimiter().consumeN(ctx, key, n)
+	if res != nil {
+		res.Degraded = true
+	}
+
+	return res, err
+}
+
+// localLimiter is the FailLocal limiter, a copy of rl with scaled limits
+func (rl *RateLimit) localLimiter() *RateLimit {
+	rl.initOnce.Do(func() {
+		store := rl.Failover.Store
+		if store == nil {
+			store = NewMemoryStore()
+		}
+
+		scale := rl.Failover.Scale
+		if scale <= 0 {
+			scale = 1
+		}
+
+		config := RateLimitConfig{
+			Name:     rl.RateLimitConfig.Name,
+			Duration: rl.RateLimitConfig.Duration,
+			Limit:    scaleLimit(rl.RateLimitConfig.Limit, scale),
+			Calendar: rl.RateLimitConfig.Calendar,
+		}
+
+		for _, rule := range rl.RateLimitConfig.Rules {
+			rule.Limit = scaleLimit(rule.Limit, scale)
+			config.Rules = append(config.Rules, rule)
+		}
+
+		for _, level := range rl.RateLimitConfig.Levels {
+			rules := make([]Rule, len(level.Rules))
+			for i, rule := range level.Rules {
+				rule.Limit = scaleLimit(rule.Limit, scale)
+				rules[i] = rule
+			}
+
+			level.Rules = rules
+			config.Levels = append(config.Levels, level)
+		}
+
+		if rl.Limits != nil {
+			config.Limits = scaledLimits{provider: rl.Limits, scale: scale}
+		}
+
+		rl.local = New(store, config)
+	})
+
+	return rl.local
+}
+
+// scaleLimit scales limit down, keeping at least one request
+func scaleLimit(limit int, scale float64) int {
+	return int(math.Max(1, math.Floor(float64(limit)*scale)))
+}
+
+type scaledLimits struct {
+	provider LimitProvider
+	scale    float64
+}
+
+func (sl scaledLimits) Limit(ctx context.Context, key string) (int, bool, error) {
+	limit, ok, err := sl.provider.Limit(ctx, key)
+	return scaleLimit(limit, sl.scale), ok, err
+}
@@ -0,0 +1,206 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errStoreDown = errors.New("store down")
+
+// flakyStore is a Store that fails while down, counting the calls it gets
+type flakyStore struct {
+	Store
+	down  int32
+	calls int32
+}
+
+func (fs *flakyStore) setDown(down bool) {
+	var v int32
+	if down {
+		v = 1
+	}
+
+	atomic.StoreInt32(&fs.down, v)
+}
+
+func (fs *flakyStore) GetItem(ctx context.Context, key string) (*RequestLog, error) {
+	atomic.AddInt32(&fs.calls, 1)
+
+	if atomic.LoadInt32(&fs.down) == 1 {
+		return nil, errStoreDown
+	}
+
+	return fs.Store.GetItem(ctx, key)
+}
+
+func TestFailover(t *testing.T) {
+	is := require.New(t)
+
+	ctx := context.Background()
+	config := RateLimitConfig{Duration: time.Second * 60, Limit: 4}
+
+	store := &flakyStore{Store: NewMemoryStore()}
+	store.setDown(true)
+
+	_, err := New(store, config).Consume(ctx, "alice")
+	is.ErrorIs(err, errStoreDown)
+
+	config.Failover = Failover{Mode: FailOpen, Retry: time.Millisecond * 50}
+	rl := New(store, config)
+
+	for i := 0; i < 6; i++ {
+		res, err := rl.Consume(ctx, "alice")
+		is.NoError(err)
+		is.True(res.Allowed)
+		is.True(res.Degraded)
+	}
+
+	// the failed store is skipped until the retry interval passes
+	is.Equal(int32(2), atomic.LoadInt32(&store.calls))
+	is.False(rl.Healthy())
+
+	store.setDown(false)
+	time.Sleep(time.Millisecond * 60)
+
+	res, err := rl.Consume(ctx, "alice")
+	is.NoError(err)
+	is.False(res.Degraded)
+	is.Equal(3, res.Remaining)
+	is.True(rl.Healthy())
+
+	store.setDown(true)
+	config.Failover = Failover{Mode: FailClosed, Retry: time.Second * 30}
+
+	res, err = New(store, config).Consume(ctx, "alice")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.True(res.Degraded)
+	is.False(res.Blocked)
+	is.Equal(time.Second*30, res.RetryAfter)
+}
+
+// conflictStore is a TxStore whose updates always conflict
+type conflictStore struct {
+	*MemoryStore
+}
+
+func (cs conflictStore) UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error {
+	return ErrTxConflict
+}
+
+func TestFailoverConflict(t *testing.T) {
+	is := require.New(t)
+
+	rl := New(conflictStore{NewMemoryStore()}, RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    4,
+		Failover: Failover{Mode: FailOpen, Retry: time.Minute},
+	})
+
+	// conflicts are returned rather than served degraded
+	for i := 0; i < 2; i++ {
+		res, err := rl.Consume(context.Background(), "alice")
+		is.ErrorIs(err, ErrTxConflict)
+		is.Nil(res)
+		is.True(rl.Healthy())
+	}
+}
+
+func TestFailoverLocal(t *testing.T) {
+	is := require.New(t)
+
+	store := &flakyStore{Store: NewMemoryStore()}
+	store.setDown(true)
+
+	rl := New(store, RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    4,
+		Limits:   StaticLimits{"enterprise": 10},
+		Failover: Failover{Mode: FailLocal, Scale: 0.5},
+	})
+
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		res, err := rl.Consume(ctx, "alice")
+		is.NoError(err)
+		is.True(res.Degraded)
+		is.Equal(2, res.Limit)
+	}
+
+	res, err := rl.Consume(ctx, "alice")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.True(res.Degraded)
+
+	res, err = rl.Consume(ctx, "enterprise")
+	is.NoError(err)
+	is.Equal(5, res.Limit)
+}
+
+func TestFailoverMiddleware(t *testing.T) {
+	is := require.New(t)
+
+	store := &flakyStore{Store: NewMemoryStore()}
+	store.setDown(true)
+
+	rl := New(store, RateLimitConfig{Duration: time.Second * 60, Limit: 1, Failover: Failover{Mode: FailOpen}})
+
+	handler := NewMiddlewareStd(rl, WithIpAddressStd("failover-ip")).Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 3; i++ {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+		is.Equal(http.StatusOK, resp.Code)
+	}
+}
+
+func TestFailoverMiddlewareRefund(t *testing.T) {
+	is := require.New(t)
+
+	store := &flakyStore{Store: NewMemoryStore()}
+	store.setDown(true)
+
+	rl := New(store, RateLimitConfig{Duration: time.Second * 60, Limit: 2, Failover: Failover{Mode: FailLocal}})
+
+	status := http.StatusOK
+	handler := NewMiddlewareStd(rl, WithIpAddressStd("refund-ip"), WithCountIfStd(func(status int) bool {
+		return status >= http.StatusBadRequest
+	})).Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(status)
+	}))
+
+	// uncounted responses are refunded to the local limiter, leaving the
+	// failed store alone
+	for i := 0; i < 4; i++ {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+		is.Equal(http.StatusOK, resp.Code)
+	}
+
+	is.Equal(int32(1), atomic.LoadInt32(&store.calls))
+
+	// failed refunds are reported
+	observer := &recordingObserver{}
+	rl = New(store, RateLimitConfig{Duration: time.Second * 60, Limit: 2, Observer: observer})
+	store.setDown(false)
+
+	handler = NewMiddlewareStd(rl, WithIpAddressStd("refund-ip"), WithCountIfStd(func(status int) bool {
+		return false
+	})).Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		store.setDown(true)
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	is.Equal(http.StatusOK, resp.Code)
+	is.Equal([]string{"allowed::refund-ip", "error:refund-ip"}, observer.events)
+}
@@ -0,0 +1,69 @@
+package xratelimit
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// HeaderStrategy selects which rate limit header fields a middleware writes on
+// the response.
+type HeaderStrategy int
+
+const (
+	// HeadersXRateLimit writes the legacy X-RateLimit-Limit, X-RateLimit-Remaining
+	// and X-RateLimit-Reset (unix timestamp) fields.
+	HeadersXRateLimit HeaderStrategy = iota
+	// HeadersIETF writes the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset
+	// (delta seconds) and RateLimit-Policy fields from the IETF httpapi draft.
+	HeadersIETF
+	// HeadersNone disables rate limit headers, including Retry-After.
+	HeadersNone
+)
+
+const (
+	HeaderXRateLimitLimit     = "X-RateLimit-Limit"
+	HeaderXRateLimitRemaining = "X-RateLimit-Remaining"
+	HeaderXRateLimitReset     = "X-RateLimit-Reset"
+	HeaderRateLimitLimit      = "RateLimit-Limit"
+	HeaderRateLimitRemaining  = "RateLimit-Remaining"
+	HeaderRateLimitReset      = "RateLimit-Reset"
+	HeaderRateLimitPolicy     = "RateLimit-Policy"
+	HeaderRetryAfter          = "Retry-After"
+)
+
+// writeHeaders sets the rate limit header fields for res using set, which lets
+// the same logic serve net/http and fasthttp headers.
+func writeHeaders(set func(key, value string), strategy HeaderStrategy, res *Result) {
+	switch strategy {
+	case HeadersXRateLimit:
+		set(HeaderXRateLimitLimit, fmt.Sprint(res.Limit))
+		set(HeaderXRateLimitRemaining, fmt.Sprint(res.Remaining))
+		set(HeaderXRateLimitReset, fmt.Sprint(res.ResetAt.Unix()))
+	case HeadersIETF:
+		set(HeaderRateLimitLimit, fmt.Sprint(res.Limit))
+		set(HeaderRateLimitRemaining, fmt.Sprint(res.Remaining))
+		set(HeaderRateLimitReset, fmt.Sprint(deltaSeconds(res.ResetAt)))
+		set(HeaderRateLimitPolicy, fmt.Sprintf("%d;w=%d", res.Limit, int64(res.Window/time.Second)))
+	}
+}
+
+// writeRetryAfter sets the Retry-After field for a rejected request.
+func writeRetryAfter(set func(key, value string), strategy HeaderStrategy, res *Result) {
+	if strategy == HeadersNone {
+		return
+	}
+
+	set(HeaderRetryAfter, fmt.Sprint(int64(math.Ceil(res.RetryAfter.Seconds()))))
+}
+
+// deltaSeconds returns the whole number of seconds until t, rounded up so
+// clients never retry before the window resets.
+func deltaSeconds(t time.Time) int64 {
+	d := time.Until(t)
+	if d <= 0 {
+		return 0
+	}
+
+	return int64(math.Ceil(d.Seconds()))
+}
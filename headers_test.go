@@ -0,0 +1,64 @@
+package xratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHeaders(t *testing.T) {
+	is := require.New(t)
+
+	res := &Result{
+		Limit:     10,
+		Remaining: 4,
+		ResetAt:   time.Now().Add(time.Second * 30),
+		Window:    time.Second * 60,
+	}
+
+	h := http.Header{}
+	writeHeaders(h.Set, HeadersXRateLimit, res)
+	is.Equal("10", h.Get(HeaderXRateLimitLimit))
+	is.Equal("4", h.Get(HeaderXRateLimitRemaining))
+	is.Equal(fmt.Sprint(res.ResetAt.Unix()), h.Get(HeaderXRateLimitReset))
+	is.Empty(h.Get(HeaderRateLimitLimit))
+
+	h = http.Header{}
+	writeHeaders(h.Set, HeadersIETF, res)
+	is.Equal("10", h.Get(HeaderRateLimitLimit))
+	is.Equal("4", h.Get(HeaderRateLimitRemaining))
+	is.Equal("30", h.Get(HeaderRateLimitReset))
+	is.Equal("10;w=60", h.Get(HeaderRateLimitPolicy))
+	is.Empty(h.Get(HeaderXRateLimitLimit))
+
+	h = http.Header{}
+	writeHeaders(h.Set, HeadersNone, res)
+	is.Empty(h)
+}
+
+func TestWriteRetryAfter(t *testing.T) {
+	is := require.New(t)
+
+	res := &Result{
+		Limit:      10,
+		Remaining:  0,
+		ResetAt:    time.Now().Add(time.Millisecond * 1500),
+		RetryAfter: time.Millisecond * 1500,
+	}
+
+	h := http.Header{}
+	writeRetryAfter(h.Set, HeadersIETF, res)
+	is.Equal("2", h.Get(HeaderRetryAfter))
+
+	h = http.Header{}
+	writeRetryAfter(h.Set, HeadersNone, res)
+	is.Empty(h.Get(HeaderRetryAfter))
+
+	res.RetryAfter = 0
+	h = http.Header{}
+	writeRetryAfter(h.Set, HeadersXRateLimit, res)
+	is.Equal("0", h.Get(HeaderRetryAfter))
+}
@@ -0,0 +1,41 @@
+package xratelimit
+
+import "fmt"
+
+// Level is an outer tier of a hierarchical policy, e.g. a user's tenant or the
+// whole service. Every level is checked together with the key's own rules and
+// a request is consumed from all of them or none.
+type Level struct {
+	Name  string                  // namespaces the level's keys in the store
+	Key   func(key string) string // derives the level's key from the request key, nil for a single shared key
+	Rules []Rule
+}
+
+// levelPrefix starts the store keys of levels, which request keys are
+// escaped not to
+const levelPrefix = "@:"
+
+func (l Level) key(key string) string {
+	if l.Key == nil {
+		return l.Name
+	}
+
+	return fmt.Sprintf("%s:%s", l.Name, l.Key(key))
+}
+
+func ruleBuckets(key string, rules []Rule) []*bucket {
+	buckets := make([]*bucket, 0, len(rules))
+
+	for _, rule := range rules {
+		suffix := rule.Name
+		if suffix == "" && rule.Calendar != nil {
+			suffix = rule.Calendar.String()
+		} else if suffix == "" {
+			suffix = rule.Duration.String()
+		}
+
+		buckets = append(buckets, &bucket{key: fmt.Sprintf("%s:%s", key, suffix), rule: rule})
+	}
+
+	return buckets
+}
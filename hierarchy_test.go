@@ -0,0 +1,152 @@
+package xratelimit
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func tenantOf(key string) string {
+	return strings.Split(key, "/")[0]
+}
+
+func TestConsumeLevels(t *testing.T) {
+	is := require.New(t)
+
+	store := NewMemoryStore()
+	rl := New(store, RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    3,
+		Levels: []Level{
+			{Name: "tenant", Key: tenantOf, Rules: []Rule{{Duration: time.Second * 60, Limit: 4}}},
+			{Name: "global", Rules: []Rule{{Duration: time.Second * 60, Limit: 5}}},
+		},
+	})
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := rl.Consume(ctx, "acme/alice")
+		is.NoError(err)
+	}
+
+	// alice is capped by her own limit
+	_, err := rl.Consume(ctx, "acme/alice")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+
+	res, err := rl.Consume(ctx, "acme/bob")
+	is.NoError(err)
+	is.Equal(4, res.Limit)
+	is.Equal(0, res.Remaining)
+
+	// bob is capped by the tenant, which must leave the global level untouched
+	res, err = rl.Consume(ctx, "acme/bob")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.Equal(4, res.Limit)
+
+	global, err := store.GetItem(ctx, "@:global:1m0s")
+	is.NoError(err)
+	is.Equal(4, global.Counter)
+
+	_, err = rl.Consume(ctx, "initech/carol")
+	is.NoError(err)
+
+	res, err = rl.Consume(ctx, "initech/carol")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.Equal(5, res.Limit)
+}
+
+func TestLevelKeys(t *testing.T) {
+	is := require.New(t)
+
+	store := NewMemoryStore()
+	rl := New(store, RateLimitConfig{
+		Name:     "api",
+		Duration: time.Second * 60,
+		Limit:    3,
+		Levels: []Level{
+			{Name: "tenant", Key: tenantOf, Rules: []Rule{{Duration: time.Second * 60, Limit: 3}}},
+			{Name: "global", Rules: []Rule{{Duration: time.Second * 60, Limit: 100}}},
+		},
+	})
+
+	ctx := context.Background()
+
+	// request keys naming a level's store key get counters of their own
+	for _, key := range []string{"tenant:acme", "@:tenant:acme", "global", "@:global"} {
+		for i := 0; i < 3; i++ {
+			_, err := rl.Consume(ctx, key)
+			is.NoError(err, key)
+		}
+	}
+
+	res, err := rl.Consume(ctx, "acme/alice")
+	is.NoError(err)
+	is.Equal(2, res.Remaining)
+
+	global, err := store.GetItem(ctx, "api:@:global:1m0s")
+	is.NoError(err)
+	is.Equal(13, global.Counter)
+
+	rlog, err := store.GetItem(ctx, "api:@@:tenant:acme")
+	is.NoError(err)
+	is.Equal(3, rlog.Counter)
+
+	is.Equal("@:tenant:acme", requestKey("@@:tenant:acme"))
+	is.Equal("tenant:acme", requestKey("tenant:acme"))
+}
+
+func TestConsumeLevelsRedis(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	config := RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    100,
+		Levels: []Level{
+			{Name: "global", Rules: []Rule{{Duration: time.Second * 60, Limit: 50}}},
+		},
+	}
+
+	// two limiters stand in for two instances sharing redis
+	instances := []*RateLimit{
+		New(NewRedisStore(WithAddr(mr.Addr())), config),
+		New(NewRedisStore(WithAddr(mr.Addr())), config),
+	}
+
+	var w sync.WaitGroup
+	var allowed int64
+
+	// the levels are checked and incremented by a script, so no request
+	// conflicts on the shared global key
+	for i := 0; i < 200; i++ {
+		w.Add(1)
+
+		go func(index int) {
+			defer w.Done()
+
+			_, err := instances[index%2].Consume(context.Background(), "user")
+			if err == nil {
+				atomic.AddInt64(&allowed, 1)
+			} else if err != ErrRateLimitExceeded {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}(i)
+	}
+
+	w.Wait()
+
+	global, err := instances[0].Store.GetItem(context.Background(), "@:global:1m0s")
+	is.NoError(err)
+	is.Equal(int(allowed), global.Counter)
+	is.Equal(50, global.Counter)
+}
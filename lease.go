@@ -0,0 +1,380 @@
+package xratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTTL is how long leased units are held when Lease.TTL is zero
+const DefaultLeaseTTL = time.Second
+
+// Lease makes a RateLimit reserve units from the store in batches and spend
+// them locally, so most requests don't reach the store. Units left when a
+// lease expires are given back to the store by a background sweep, which runs
+// every TTL while the RateLimit holds leases, or by ReleaseLeases. Until then
+// other instances can't use the units an instance holds, so a lease should be
+// small compared to the limit.
+//
+// The batch size follows the rate a key is consumed at, reserving what the
+// key is expected to use in TTL, between Size and MaxSize.
+type Lease struct {
+	Size    int // units reserved at a time when the rate is unknown, and the least reserved
+	MaxSize int // most units reserved at a time, Size when smaller
+	TTL     time.Duration
+}
+
+func (l Lease) enabled() bool {
+	return l.Size > 0
+}
+
+func (l Lease) ttl() time.Duration {
+	if l.TTL <= 0 {
+		return DefaultLeaseTTL
+	}
+
+	return l.TTL
+}
+
+// size adapts the batch size to the rate units were spent at since the last
+// reservation
+func (l Lease) size(spent int, elapsed time.Duration) int {
+	max := l.MaxSize
+	if max < l.Size {
+		max = l.Size
+	}
+
+	if elapsed <= 0 {
+		return l.Size
+	}
+
+	expected := int(math.Ceil(float64(spent) / elapsed.Seconds() * l.ttl().Seconds()))
+
+	switch {
+	case expected < l.Size:
+		return l.Size
+	case expected > max:
+		return max
+	}
+
+	return expected
+}
+
+// lease is the units an instance holds for one bucket
+type lease struct {
+	window   time.Time // start of the window the units were taken from
+	counter  int       // the window's counter in the store after the reservation
+	units    int       // units left to spend
+	size     int       // units to reserve next time
+	spent    int       // units spent since the reservation
+	leasedAt time.Time
+	expires  time.Time
+}
+
+// leases holds a RateLimit's leases by bucket key
+type leases struct {
+	mu       sync.Mutex
+	entries  map[string]*lease
+	sweeping bool // a sweep is running, until no leases are left
+}
+
+// MaxLeases is the number of leases after which a RateLimit drops those of
+// windows that have ended
+const MaxLeases = 10000
+
+// spend takes n units from the leases of every bucket when they all hold
+// enough, reporting the result
+func (rl *RateLimit) spend(key string, buckets []*bucket, n int, now time.Time) (*Result, bool) {
+	rl.leases.mu.Lock()
+	defer rl.leases.mu.Unlock()
+
+	for _, b := range buckets {
+		l, ok := rl.leases.entries[b.key]
+		if !ok || l.units < n || !now.Before(l.expires) || !rl.current(b, l.window, now) {
+			return nil, false
+		}
+	}
+
+	results := make([]*Result, 0, len(buckets))
+
+	for _, b := range buckets {
+		l := rl.leases.entries[b.key]
+		l.units -= n
+		l.spent += n
+
+		b.log = &RequestLog{Timestamp: l.window, Counter: l.counter - l.units}
+		results = append(results, rl.result(key, b, true))
+	}
+
+	return mostRestrictive(results), true
+}
+
+// current reports whether the window starting at start is still running
+func (rl *RateLimit) current(b *bucket, start time.Time, now time.Time) bool {
+	return rl.window(b, &RequestLog{Timestamp: start}, now).Timestamp.Equal(start)
+}
+
+// detach takes the leases of buckets out, to be given back to the store
+func (rl *RateLimit) detach(buckets []*bucket, now time.Time) []*lease {
+	rl.leases.mu.Lock()
+	defer rl.leases.mu.Unlock()
+
+	if rl.leases.entries == nil {
+		rl.leases.entries = make(map[string]*lease)
+	}
+
+	detached := make([]*lease, len(buckets))
+
+	for i, b := range buckets {
+		l, ok := rl.leases.entries[b.key]
+		if !ok {
+			detached[i] = &lease{size: rl.Lease.Size}
+			continue
+		}
+
+		detached[i] = &lease{
+			window: l.window,
+			units:  l.units,
+			size:   rl.Lease.size(l.spent, now.Sub(l.leasedAt)),
+		}
+
+		l.units = 0
+	}
+
+	return detached
+}
+
+// consumeLeased is consumeN for RateLimits with a Lease
+func (rl *RateLimit) consumeLeased(ctx context.Context, key string, n int, buckets []*bucket, now time.Time) (*Result, error) {
+	if res, ok := rl.spend(key, buckets, n, now); ok {
+		return res, nil
+	}
+
+	detached := rl.detach(buckets, now)
+	grants := make([]int, len(buckets))
+
+	var res *Result
+	var exceeded bool
+
+	err := rl.update(ctx, buckets, now, func(buckets []*bucket) bool {
+		var rejected []*Result
+
+		for i, b := range buckets {
+			// give back what is left of the previous lease
+			if d := detached[i]; d.units > 0 && b.log.Timestamp.Equal(d.window) {
+				b.log.Counter -= d.units
+				if b.log.Counter < 0 {
+					b.log.Counter = 0
+				}
+			}
+
+			available := b.rule.Limit - b.log.Counter
+			if available < n {
+				rejected = append(rejected, rl.result(key, b, false))
+			}
+
+			grants[i] = detached[i].size
+			if grants[i] < n {
+				grants[i] = n
+			}
+
+			if grants[i] > available {
+				grants[i] = available
+			}
+		}
+
+		if len(rejected) > 0 {
+			res, exceeded = mostRestrictive(rejected), true
+			return true
+		}
+
+		results := make([]*Result, 0, len(buckets))
+
+		for i, b := range buckets {
+			b.log.Counter += grants[i]
+			results = append(results, rl.result(key, b, true))
+		}
+
+		res, exceeded = mostRestrictive(results), false
+		return true
+	})
+
+	if err != nil {
+		// the detached units are lost with the store, they expire with their window
+		rl.storeError(key, err)
+		return rl.storeFailed(ctx, key, n, buckets, now, err)
+	}
+
+	rl.storeRecovered()
+
+	if exceeded {
+		return res, ErrRateLimitExceeded
+	}
+
+	rl.attach(buckets, grants, detached, n, now)
+
+	// the result counts the units leased but not spent as remaining
+	results := make([]*Result, 0, len(buckets))
+
+	for i, b := range buckets {
+		b.log.Counter -= grants[i] - n
+		results = append(results, rl.result(key, b, true))
+	}
+
+	return mostRestrictive(results), nil
+}
+
+// attach stores the leases granted to buckets, n units already spent
+func (rl *RateLimit) attach(buckets []*bucket, grants []int, detached []*lease, n int, now time.Time) {
+	rl.leases.mu.Lock()
+	defer rl.leases.mu.Unlock()
+
+	if len(rl.leases.entries) >= MaxLeases {
+		for k, l := range rl.leases.entries {
+			if l.units == 0 && !now.Before(l.expires) {
+				delete(rl.leases.entries, k)
+			}
+		}
+	}
+
+	for i, b := range buckets {
+		l := &lease{
+			window:   b.log.Timestamp,
+			counter:  b.log.Counter,
+			units:    grants[i] - n,
+			size:     detached[i].size,
+			spent:    n,
+			leasedAt: now,
+			expires:  now.Add(rl.Lease.ttl()),
+		}
+
+		// keep units reserved concurrently for the same window
+		if old, ok := rl.leases.entries[b.key]; ok && old.window.Equal(l.window) {
+			l.units += old.units
+		}
+
+		rl.leases.entries[b.key] = l
+	}
+
+	if !rl.leases.sweeping {
+		rl.leases.sweeping = true
+		go rl.sweepLeases()
+	}
+}
+
+// sweepLeases gives the units of expired leases back to the store every TTL,
+// stopping once no leases are left
+func (rl *RateLimit) sweepLeases() {
+	ticker := time.NewTicker(rl.Lease.ttl())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := rl.releaseLeases(context.Background(), func(l *lease, now time.Time) bool {
+			return !now.Before(l.expires)
+		})
+
+		if err != nil {
+			// the units are lost with the store, they expire with their window
+			rl.storeError("", err)
+		}
+
+		rl.leases.mu.Lock()
+		if len(rl.leases.entries) == 0 {
+			rl.leases.sweeping = false
+			rl.leases.mu.Unlock()
+
+			return
+		}
+		rl.leases.mu.Unlock()
+	}
+}
+
+// unspent returns the units this instance holds for b's current window
+func (rl *RateLimit) unspent(b *bucket) int {
+	rl.leases.mu.Lock()
+	defer rl.leases.mu.Unlock()
+
+	if l, ok := rl.leases.entries[b.key]; ok && l.window.Equal(b.log.Timestamp) {
+		return l.units
+	}
+
+	return 0
+}
+
+// dropLeases forgets the leases of buckets without giving their units back
+func (rl *RateLimit) dropLeases(buckets []*bucket) {
+	rl.leases.mu.Lock()
+	defer rl.leases.mu.Unlock()
+
+	for _, b := range buckets {
+		delete(rl.leases.entries, b.key)
+	}
+}
+
+// ReleaseLeases gives every unit leased but not spent back to the store, e.g.
+// before shutting down
+func (rl *RateLimit) ReleaseLeases(ctx context.Context) error {
+	return rl.releaseLeases(ctx, func(l *lease, now time.Time) bool {
+		return true
+	})
+}
+
+// releaseLeases forgets the leases release matches, giving their unspent
+// units back to the store
+func (rl *RateLimit) releaseLeases(ctx context.Context, release func(l *lease, now time.Time) bool) error {
+	now := time.Now()
+
+	rl.leases.mu.Lock()
+
+	var keys []string
+	var held []*lease
+
+	for k, l := range rl.leases.entries {
+		if !release(l, now) {
+			continue
+		}
+
+		if l.units > 0 {
+			keys = append(keys, k)
+			held = append(held, &lease{window: l.window, units: l.units})
+		}
+
+		delete(rl.leases.entries, k)
+	}
+
+	rl.leases.mu.Unlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	update := func(logs []*RequestLog) ([]*RequestLog, error) {
+		writes := make([]*RequestLog, len(logs))
+
+		for i, rlog := range logs {
+			if rlog == nil || !rlog.Timestamp.Equal(held[i].window) {
+				continue
+			}
+
+			w := *rlog
+			w.Counter -= held[i].units
+			if w.Counter < 0 {
+				w.Counter = 0
+			}
+
+			writes[i] = &w
+		}
+
+		return writes, nil
+	}
+
+	if _, ok := rl.Store.(TxStore); ok {
+		return rl.updateItems(ctx, keys, update)
+	}
+
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	return updateItems(ctx, rl.Store, keys, update)
+}
@@ -0,0 +1,191 @@
+package xratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the transactions that reach a MemoryStore
+type countingStore struct {
+	*MemoryStore
+	updates int32
+}
+
+func (cs *countingStore) UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error {
+	atomic.AddInt32(&cs.updates, 1)
+	return cs.MemoryStore.UpdateItems(ctx, keys, fn)
+}
+
+func TestLease(t *testing.T) {
+	is := require.New(t)
+
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	rl := New(store, RateLimitConfig{Duration: time.Minute, Limit: 100, Lease: Lease{Size: 10, TTL: time.Minute}})
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		res, err := rl.Consume(ctx, "alice")
+		is.NoError(err)
+		is.Equal(100-i-1, res.Remaining)
+	}
+
+	is.Equal(int32(5), atomic.LoadInt32(&store.updates))
+
+	// the store counts the whole lease, this instance can still spend it
+	rlog, err := store.GetItem(ctx, "alice")
+	is.NoError(err)
+	is.Equal(50, rlog.Counter)
+
+	rl.Consume(ctx, "alice")
+
+	res, err := rl.Peek(ctx, "alice")
+	is.NoError(err)
+	is.Equal(49, res.Remaining)
+
+	// unspent units go back to the store
+	is.NoError(rl.ReleaseLeases(ctx))
+
+	rlog, err = store.GetItem(ctx, "alice")
+	is.NoError(err)
+	is.Equal(51, rlog.Counter)
+}
+
+func TestLeaseShared(t *testing.T) {
+	is := require.New(t)
+
+	store := NewMemoryStore()
+	config := RateLimitConfig{Duration: time.Minute, Limit: 25, Lease: Lease{Size: 10, TTL: time.Minute}}
+	limiters := []*RateLimit{New(store, config), New(store, config)}
+	ctx := context.Background()
+
+	// instances alternating until both reject never admit more than the limit
+	var total int
+	for rejected := 0; rejected < len(limiters); {
+		rejected = 0
+
+		for _, rl := range limiters {
+			if _, err := rl.Consume(ctx, "alice"); err != nil {
+				is.ErrorIs(err, ErrRateLimitExceeded)
+				rejected++
+				continue
+			}
+
+			total++
+		}
+	}
+
+	is.Equal(25, total)
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	is := require.New(t)
+
+	store := NewMemoryStore()
+	config := RateLimitConfig{Duration: time.Minute, Limit: 20, Lease: Lease{Size: 10, TTL: time.Millisecond * 20}}
+	a, b := New(store, config), New(store, config)
+	ctx := context.Background()
+
+	_, err := a.Consume(ctx, "alice")
+	is.NoError(err)
+
+	_, err = b.Consume(ctx, "alice")
+	is.NoError(err)
+
+	// b can't lease the units a holds
+	for i := 0; i < 9; i++ {
+		_, err := b.Consume(ctx, "alice")
+		is.NoError(err)
+	}
+
+	_, err = b.Consume(ctx, "alice")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+
+	// once a's lease expires its next reservation gives back the 9 unspent units
+	time.Sleep(time.Millisecond * 30)
+
+	_, err = a.Consume(ctx, "alice")
+	is.NoError(err)
+
+	rlog, err := store.GetItem(ctx, "alice")
+	is.NoError(err)
+	is.Equal(20, rlog.Counter)
+
+	is.NoError(a.ReleaseLeases(ctx))
+
+	res, err := b.Consume(ctx, "alice")
+	is.NoError(err)
+	is.Equal(7, res.Remaining)
+}
+
+func TestLeaseSweep(t *testing.T) {
+	is := require.New(t)
+
+	store := NewMemoryStore()
+	config := RateLimitConfig{Duration: time.Minute, Limit: 10, Lease: Lease{Size: 5, TTL: time.Millisecond * 20}}
+	a, b := New(store, config), New(store, config)
+	ctx := context.Background()
+
+	_, err := a.Consume(ctx, "alice")
+	is.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		_, err := b.Consume(ctx, "alice")
+		is.NoError(err)
+	}
+
+	_, err = b.Consume(ctx, "alice")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+
+	// a goes idle, and the units its lease held go back once it expires
+	time.Sleep(time.Millisecond * 60)
+
+	rlog, err := store.GetItem(ctx, "alice")
+	is.NoError(err)
+	is.Equal(6, rlog.Counter)
+
+	for i := 0; i < 4; i++ {
+		_, err := b.Consume(ctx, "alice")
+		is.NoError(err)
+	}
+
+	_, err = b.Consume(ctx, "alice")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+
+	// the sweep stops once no leases are left
+	time.Sleep(time.Millisecond * 60)
+
+	for _, rl := range []*RateLimit{a, b} {
+		rl.leases.mu.Lock()
+		is.False(rl.leases.sweeping)
+		rl.leases.mu.Unlock()
+	}
+}
+
+func TestLeaseSize(t *testing.T) {
+	is := require.New(t)
+
+	lease := Lease{Size: 5, MaxSize: 100, TTL: time.Second}
+
+	is.Equal(5, lease.size(0, 0))
+	is.Equal(5, lease.size(1, time.Second))
+	is.Equal(40, lease.size(20, time.Millisecond*500))
+	is.Equal(100, lease.size(1000, time.Second))
+	is.Equal(5, Lease{Size: 5}.size(1000, time.Second))
+
+	// a busy key reserves bigger batches
+	store := NewMemoryStore()
+	rl := New(store, RateLimitConfig{Duration: time.Minute, Limit: 1000, Lease: lease})
+
+	for i := 0; i < 6; i++ {
+		_, err := rl.Consume(context.Background(), "alice")
+		is.NoError(err)
+	}
+
+	rlog, err := store.GetItem(context.Background(), "alice")
+	is.NoError(err)
+	is.Equal(5+100, rlog.Counter)
+}
@@ -0,0 +1,267 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LimitProvider resolves the limit for a key, e.g. from the customer's plan.
+// ok is false when the provider has no limit for key and the RateLimit's
+// configured Limit applies. Providers only replace Limit, not Rules or the
+// limits of Levels.
+type LimitProvider interface {
+	Limit(ctx context.Context, key string) (limit int, ok bool, err error)
+}
+
+// StaticLimits is a LimitProvider backed by a map of key to limit
+type StaticLimits map[string]int
+
+func (sl StaticLimits) Limit(ctx context.Context, key string) (int, bool, error) {
+	limit, ok := sl[key]
+	return limit, ok, nil
+}
+
+// Plans is a LimitProvider that assigns keys to named plans
+type Plans struct {
+	Limits map[string]int    `json:"plans" yaml:"plans"` // plan name to limit
+	Keys   map[string]string `json:"keys" yaml:"keys"`   // key to plan name
+}
+
+func (p *Plans) Limit(ctx context.Context, key string) (int, bool, error) {
+	plan, ok := p.Keys[key]
+	if !ok {
+		return 0, false, nil
+	}
+
+	limit, ok := p.Limits[plan]
+	return limit, ok, nil
+}
+
+// FileLimits serves Plans read from a YAML or JSON file, mostly useful for
+// tests and small deployments.
+//
+//	plans: {free: 100, pro: 1000}
+//	keys: {alice: pro, bob: free}
+type FileLimits struct {
+	path  string
+	mu    sync.RWMutex
+	plans *Plans
+}
+
+func NewFileLimits(path string) (*FileLimits, error) {
+	fl := &FileLimits{path: path}
+
+	if err := fl.Reload(); err != nil {
+		return nil, err
+	}
+
+	return fl, nil
+}
+
+// Reload reads the file again, keeping the current plans if it is invalid
+func (fl *FileLimits) Reload() error {
+	data, err := ioutil.ReadFile(fl.path)
+	if err != nil {
+		return err
+	}
+
+	// JSON is valid YAML, so one decoder handles both formats
+	var plans Plans
+	if err := yaml.UnmarshalStrict(data, &plans); err != nil {
+		return err
+	}
+
+	fl.mu.Lock()
+	fl.plans = &plans
+	fl.mu.Unlock()
+
+	return nil
+}
+
+func (fl *FileLimits) Limit(ctx context.Context, key string) (int, bool, error) {
+	fl.mu.RLock()
+	defer fl.mu.RUnlock()
+
+	return fl.plans.Limit(ctx, key)
+}
+
+// MaxCachedLimits is the number of entries after which a CachedLimits drops
+// expired entries
+const MaxCachedLimits = 10000
+
+// CachedLimits caches the answers of a slower LimitProvider, such as one
+// backed by a database, for ttl. Errors are not cached.
+type CachedLimits struct {
+	provider LimitProvider
+	ttl      time.Duration
+	mu       sync.Mutex
+	entries  map[string]cachedLimit
+}
+
+type cachedLimit struct {
+	limit   int
+	ok      bool
+	expires time.Time
+}
+
+func NewCachedLimits(provider LimitProvider, ttl time.Duration) *CachedLimits {
+	return &CachedLimits{
+		provider: provider,
+		ttl:      ttl,
+		entries:  make(map[string]cachedLimit),
+	}
+}
+
+func (cl *CachedLimits) Limit(ctx context.Context, key string) (int, bool, error) {
+	now := time.Now()
+
+	cl.mu.Lock()
+	entry, found := cl.entries[key]
+	cl.mu.Unlock()
+
+	if found && now.Before(entry.expires) {
+		return entry.limit, entry.ok, nil
+	}
+
+	limit, ok, err := cl.provider.Limit(ctx, key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if len(cl.entries) >= MaxCachedLimits {
+		for k, v := range cl.entries {
+			if !now.Before(v.expires) {
+				delete(cl.entries, k)
+			}
+		}
+	}
+
+	cl.entries[key] = cachedLimit{limit: limit, ok: ok, expires: now.Add(cl.ttl)}
+
+	return limit, ok, nil
+}
+
+// Invalidate drops key from the cache, e.g. after a customer changes plan
+func (cl *CachedLimits) Invalidate(key string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	delete(cl.entries, key)
+}
+
+// ErrOverrideRules is returned when overriding a limit of a policy with Rules
+var ErrOverrideRules = errors.New("limits can't be overridden for policies with rules")
+
+// overrides holds the limits set by OverrideLimit
+type overrides struct {
+	mu      sync.Mutex
+	entries map[string]LimitOverride
+}
+
+// LimitOverride is a limit set for a key until it expires
+type LimitOverride struct {
+	Limit   int       `json:"limit"`
+	Expires time.Time `json:"expires"`
+}
+
+// OverrideLimit sets key's limit for ttl, ahead of Limits, e.g. to unblock a
+// customer during an incident. Overrides are held in memory by this RateLimit
+// only, and carried over to the policy's new RateLimit when Policies reloads.
+// Like a LimitProvider they replace Limit, so policies with Rules return
+// ErrOverrideRules.
+func (rl *RateLimit) OverrideLimit(key string, limit int, ttl time.Duration) error {
+	if len(rl.RateLimitConfig.Rules) > 0 {
+		return ErrOverrideRules
+	}
+
+	rl.overrides.mu.Lock()
+	defer rl.overrides.mu.Unlock()
+
+	if rl.overrides.entries == nil {
+		rl.overrides.entries = make(map[string]LimitOverride)
+	}
+
+	now := time.Now()
+
+	if len(rl.overrides.entries) >= MaxCachedLimits {
+		for k, o := range rl.overrides.entries {
+			if !now.Before(o.Expires) {
+				delete(rl.overrides.entries, k)
+			}
+		}
+	}
+
+	rl.overrides.entries[key] = LimitOverride{Limit: limit, Expires: now.Add(ttl)}
+
+	return nil
+}
+
+// ClearOverride drops key's override, its usual limit applies again
+func (rl *RateLimit) ClearOverride(key string) {
+	rl.overrides.mu.Lock()
+	defer rl.overrides.mu.Unlock()
+
+	delete(rl.overrides.entries, key)
+}
+
+// Override returns key's override, ok false when it has none
+func (rl *RateLimit) Override(key string) (override LimitOverride, ok bool) {
+	rl.overrides.mu.Lock()
+	defer rl.overrides.mu.Unlock()
+
+	o, ok := rl.overrides.entries[key]
+	if !ok || !time.Now().Before(o.Expires) {
+		return LimitOverride{}, false
+	}
+
+	return o, true
+}
+
+func (rl *RateLimit) overriddenLimit(key string, now time.Time) (int, bool) {
+	rl.overrides.mu.Lock()
+	defer rl.overrides.mu.Unlock()
+
+	o, ok := rl.overrides.entries[key]
+	if !ok {
+		return 0, false
+	}
+
+	if !now.Before(o.Expires) {
+		delete(rl.overrides.entries, key)
+		return 0, false
+	}
+
+	return o.Limit, true
+}
+
+// takeOverrides copies old's unexpired overrides, e.g. when a policy is
+// rebuilt on reload
+func (rl *RateLimit) takeOverrides(old *RateLimit) {
+	old.overrides.mu.Lock()
+	defer old.overrides.mu.Unlock()
+
+	rl.overrides.mu.Lock()
+	defer rl.overrides.mu.Unlock()
+
+	now := time.Now()
+
+	for k, o := range old.overrides.entries {
+		if !now.Before(o.Expires) {
+			continue
+		}
+
+		if rl.overrides.entries == nil {
+			rl.overrides.entries = make(map[string]LimitOverride)
+		}
+
+		rl.overrides.entries[k] = o
+	}
+}
@@ -0,0 +1,132 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingLimits struct {
+	LimitProvider
+	calls int
+	err   error
+}
+
+func (cl *countingLimits) Limit(ctx context.Context, key string) (int, bool, error) {
+	cl.calls++
+
+	if cl.err != nil {
+		return 0, false, cl.err
+	}
+
+	return cl.LimitProvider.Limit(ctx, key)
+}
+
+func TestLimitProvider(t *testing.T) {
+	is := require.New(t)
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    1,
+		Limits:   StaticLimits{"enterprise-key": 3},
+	})
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		res, err := rl.Consume(ctx, "enterprise-key")
+		is.NoError(err)
+		is.Equal(3, res.Limit)
+	}
+
+	_, err := rl.Consume(ctx, "enterprise-key")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+
+	// unknown keys get the default
+	res, err := rl.Peek(ctx, "free-key")
+	is.NoError(err)
+	is.Equal(1, res.Limit)
+}
+
+func TestFileLimits(t *testing.T) {
+	is := require.New(t)
+
+	dir, err := ioutil.TempDir("", "x-ratelimit-limits")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plans.json")
+	is.NoError(ioutil.WriteFile(path, []byte(`{"plans": {"free": 10, "pro": 100}, "keys": {"alice": "pro", "bob": "free", "eve": "gold"}}`), 0644))
+
+	fl, err := NewFileLimits(path)
+	is.NoError(err)
+
+	cases := map[string]int{"alice": 100, "bob": 10, "eve": -1, "mallory": -1}
+
+	for key, want := range cases {
+		limit, ok, err := fl.Limit(context.Background(), key)
+		is.NoError(err)
+		is.Equal(want >= 0, ok, key)
+
+		if ok {
+			is.Equal(want, limit, key)
+		}
+	}
+
+	is.NoError(ioutil.WriteFile(path, []byte("plans: {free: 10}\nkeys: {alice: free}\n"), 0644))
+	is.NoError(fl.Reload())
+
+	limit, _, err := fl.Limit(context.Background(), "alice")
+	is.NoError(err)
+	is.Equal(10, limit)
+
+	is.NoError(ioutil.WriteFile(path, []byte("plans: [free]"), 0644))
+	is.Error(fl.Reload())
+
+	limit, _, err = fl.Limit(context.Background(), "alice")
+	is.NoError(err)
+	is.Equal(10, limit)
+}
+
+func TestCachedLimits(t *testing.T) {
+	is := require.New(t)
+
+	provider := &countingLimits{LimitProvider: StaticLimits{"alice": 5}}
+	cl := NewCachedLimits(provider, time.Millisecond*50)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		limit, ok, err := cl.Limit(ctx, "alice")
+		is.NoError(err)
+		is.True(ok)
+		is.Equal(5, limit)
+
+		_, ok, err = cl.Limit(ctx, "bob")
+		is.NoError(err)
+		is.False(ok)
+	}
+
+	is.Equal(2, provider.calls)
+
+	time.Sleep(time.Millisecond * 60)
+
+	_, _, err := cl.Limit(ctx, "alice")
+	is.NoError(err)
+	is.Equal(3, provider.calls)
+
+	cl.Invalidate("alice")
+	provider.err = errors.New("database unavailable")
+
+	_, _, err = cl.Limit(ctx, "alice")
+	is.Error(err)
+
+	_, _, err = cl.Limit(ctx, "alice")
+	is.Error(err)
+	is.Equal(5, provider.calls)
+}
@@ -0,0 +1,158 @@
+package xratelimit
+
+import (
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"go.uber.org/zap"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+	LogOff // disables an event
+)
+
+// Logger writes a message with alternating key and value fields, like
+// log/slog
+type Logger interface {
+	Log(level LogLevel, msg string, keysAndValues ...interface{})
+}
+
+// LogEvent is a kind of event a RateLimit or Policies logs
+type LogEvent string
+
+const (
+	LogStoreError      LogEvent = "store_error"      // a store call failed
+	LogRejected        LogEvent = "rejected"         // a request was rejected, subject to sampling
+	LogMiddlewareError LogEvent = "middleware_error" // a middleware couldn't resolve the request's key
+	LogReload          LogEvent = "reload"           // Policies reloaded the policy file
+	LogReloadError     LogEvent = "reload_error"     // the policy file could not be reloaded
+)
+
+// DefaultLogLevels are the levels events are logged at unless overridden
+var DefaultLogLevels = map[LogEvent]LogLevel{
+	LogStoreError:      LogError,
+	LogRejected:        LogInfo,
+	LogMiddlewareError: LogWarn,
+	LogReload:          LogInfo,
+	LogReloadError:     LogError,
+}
+
+// Logging decides which events reach a Logger and at which level
+type Logging struct {
+	Logger           Logger
+	Levels           map[LogEvent]LogLevel // overrides DefaultLogLevels, LogOff disables an event
+	SampleRejections uint64                // logs one in every n rejections, all of them when n <= 1
+
+	rejections uint64
+}
+
+func NewLogging(logger Logger) *Logging {
+	return &Logging{Logger: logger}
+}
+
+func (l *Logging) level(event LogEvent) LogLevel {
+	if level, ok := l.Levels[event]; ok {
+		return level
+	}
+
+	return DefaultLogLevels[event]
+}
+
+func (l *Logging) log(event LogEvent, msg string, keysAndValues ...interface{}) {
+	if l == nil || l.Logger == nil {
+		return
+	}
+
+	level := l.level(event)
+	if level >= LogOff {
+		return
+	}
+
+	if event == LogRejected && l.SampleRejections > 1 {
+		if atomic.AddUint64(&l.rejections, 1)%l.SampleRejections != 1 {
+			return
+		}
+	}
+
+	l.Logger.Log(level, msg, append([]interface{}{"event", string(event)}, keysAndValues...)...)
+}
+
+// SlogStyle is satisfied by *slog.Logger and other loggers with leveled
+// methods taking alternating keys and values
+type SlogStyle interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type slogLogger struct {
+	logger SlogStyle
+}
+
+func NewSlogLogger(logger SlogStyle) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (sl *slogLogger) Log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	switch level {
+	case LogDebug:
+		sl.logger.Debug(msg, keysAndValues...)
+	case LogInfo:
+		sl.logger.Info(msg, keysAndValues...)
+	case LogWarn:
+		sl.logger.Warn(msg, keysAndValues...)
+	default:
+		sl.logger.Error(msg, keysAndValues...)
+	}
+}
+
+type zapLogger struct {
+	logger *zap.SugaredLogger
+}
+
+func NewZapLogger(logger *zap.Logger) Logger {
+	return &zapLogger{logger: logger.Sugar()}
+}
+
+func (zl *zapLogger) Log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	switch level {
+	case LogDebug:
+		zl.logger.Debugw(msg, keysAndValues...)
+	case LogInfo:
+		zl.logger.Infow(msg, keysAndValues...)
+	case LogWarn:
+		zl.logger.Warnw(msg, keysAndValues...)
+	default:
+		zl.logger.Errorw(msg, keysAndValues...)
+	}
+}
+
+type zerologLogger struct {
+	logger zerolog.Logger
+}
+
+func NewZerologLogger(logger zerolog.Logger) Logger {
+	return &zerologLogger{logger: logger}
+}
+
+func (zl *zerologLogger) Log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	zlevel := zerolog.ErrorLevel
+
+	switch level {
+	case LogDebug:
+		zlevel = zerolog.DebugLevel
+	case LogInfo:
+		zlevel = zerolog.InfoLevel
+	case LogWarn:
+		zlevel = zerolog.WarnLevel
+	}
+
+	zl.logger.WithLevel(zlevel).Fields(keysAndValues).Msg(msg)
+}
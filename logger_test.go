@@ -0,0 +1,113 @@
+package xratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	entries []logEntry
+}
+
+func (rl *recordingLogger) Log(level LogLevel, msg string, keysAndValues ...interface{}) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+
+	rl.entries = append(rl.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func TestLogging(t *testing.T) {
+	is := require.New(t)
+
+	logger := &recordingLogger{}
+	logging := NewLogging(logger)
+	logging.SampleRejections = 3
+
+	rl := New(NewMemoryStore(), RateLimitConfig{Name: "api", Duration: time.Second * 60, Limit: 1, Logging: logging})
+	ctx := context.Background()
+
+	for i := 0; i < 8; i++ {
+		rl.Consume(ctx, "alice")
+	}
+
+	// seven rejections sampled one in three
+	is.Len(logger.entries, 3)
+	is.Equal(LogInfo, logger.entries[0].level)
+	is.Equal("rejected", logger.entries[0].fields["event"])
+	is.Equal("alice", logger.entries[0].fields["key"])
+	is.Equal("api", logger.entries[0].fields["policy"])
+
+	logger.entries = nil
+	logging.Levels = map[LogEvent]LogLevel{LogRejected: LogOff, LogMiddlewareError: LogDebug}
+
+	rl.Consume(ctx, "alice")
+	is.Empty(logger.entries)
+
+	rl.Store = failingStore{NewMemoryStore()}
+	rl.Consume(ctx, "bob")
+	is.Len(logger.entries, 1)
+	is.Equal(LogError, logger.entries[0].level)
+	is.Equal(context.DeadlineExceeded, logger.entries[0].fields["error"])
+
+	key := func(r *http.Request) (string, error) {
+		return "", errors.New("missing api key")
+	}
+
+	handler := NewMiddlewareStd(rl, WithKeyStd(key)).Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	is.Len(logger.entries, 2)
+	is.Equal(LogDebug, logger.entries[1].level)
+	is.Equal("middleware_error", logger.entries[1].fields["event"])
+}
+
+type slogStyleLogger struct {
+	lines []string
+}
+
+func (sl *slogStyleLogger) log(level, msg string, args ...interface{}) {
+	sl.lines = append(sl.lines, strings.TrimSpace(fmt.Sprintln(append([]interface{}{level, msg}, args...)...)))
+}
+
+func (sl *slogStyleLogger) Debug(msg string, args ...interface{}) { sl.log("DEBUG", msg, args...) }
+func (sl *slogStyleLogger) Info(msg string, args ...interface{})  { sl.log("INFO", msg, args...) }
+func (sl *slogStyleLogger) Warn(msg string, args ...interface{})  { sl.log("WARN", msg, args...) }
+func (sl *slogStyleLogger) Error(msg string, args ...interface{}) { sl.log("ERROR", msg, args...) }
+
+func TestLoggerAdapters(t *testing.T) {
+	is := require.New(t)
+
+	sl := &slogStyleLogger{}
+	NewSlogLogger(sl).Log(LogWarn, "key error", "policy", "api")
+	is.Equal([]string{"WARN key error policy api"}, sl.lines)
+
+	core, logs := observer.New(zap.DebugLevel)
+	NewZapLogger(zap.New(core)).Log(LogError, "store error", "policy", "api", "key", "alice")
+	is.Equal(1, logs.Len())
+	is.Equal(zap.ErrorLevel, logs.All()[0].Level)
+	is.Equal(map[string]interface{}{"policy": "api", "key": "alice"}, logs.All()[0].ContextMap())
+
+	var buf bytes.Buffer
+	NewZerologLogger(zerolog.New(&buf)).Log(LogInfo, "rejected", "policy", "api", "limit", 10)
+	is.JSONEq(`{"level":"info","message":"rejected","policy":"api","limit":10}`, buf.String())
+}
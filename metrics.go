@@ -0,0 +1,118 @@
+package xratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Decisions counted by Metrics
+const (
+	DecisionAllowed  = "allowed"
+	DecisionRejected = "rejected"
+	DecisionError    = "error"
+	DecisionSkipped  = "skipped"
+)
+
+// Metrics collects Prometheus metrics for the RateLimits it is set on:
+// decisions per policy, store latency per backend and the number of entries
+// held by MemoryStores. Keys are never used as labels. Register it once and
+// share it between RateLimits.
+//
+//	metrics := NewMetrics("myapp")
+//	prometheus.MustRegister(metrics)
+//	rl := New(store, RateLimitConfig{Limit: 10, Duration: time.Second, Metrics: metrics})
+type Metrics struct {
+	decisions     *prometheus.CounterVec
+	storeDuration *prometheus.HistogramVec
+	memoryEntries *prometheus.Desc
+
+	mu     sync.Mutex
+	stores map[*MemoryStore]bool
+}
+
+// NewMetrics creates the collectors, with names prefixed by namespace when set
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ratelimit",
+			Name:      "decisions_total",
+			Help:      "Rate limit decisions by policy and outcome.",
+		}, []string{"policy", "decision"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "ratelimit",
+			Name:      "store_duration_seconds",
+			Help:      "Latency of store operations by backend.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"backend", "operation"}),
+		memoryEntries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ratelimit", "memory_store_entries"),
+			"Entries held by memory stores.",
+			nil, nil,
+		),
+		stores: make(map[*MemoryStore]bool),
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.decisions.Describe(ch)
+	m.storeDuration.Describe(ch)
+	ch <- m.memoryEntries
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.decisions.Collect(ch)
+	m.storeDuration.Collect(ch)
+
+	m.mu.Lock()
+	var entries int
+	for ms := range m.stores {
+		entries += ms.len()
+	}
+	m.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(m.memoryEntries, prometheus.GaugeValue, float64(entries))
+}
+
+// watch includes store in the memory store entry count
+func (m *Metrics) watch(store Store) {
+	ms, ok := store.(*MemoryStore)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stores[ms] = true
+}
+
+func (m *Metrics) decision(policy, decision string) {
+	m.decisions.WithLabelValues(policy, decision).Inc()
+}
+
+func (m *Metrics) storeOp(store Store, operation string, start time.Time) {
+	m.storeDuration.WithLabelValues(storeBackend(store), operation).Observe(time.Since(start).Seconds())
+}
+
+func storeBackend(store Store) string {
+	switch s := store.(type) {
+	case *BreakerStore:
+		return storeBackend(s.store)
+	case *TieredStore:
+		// calls are mostly served from memory, so keep them apart from the
+		// remote store's own
+		return "tiered-" + storeBackend(s.remote)
+	case *MemoryStore:
+		return StoreMemory
+	case *RedisStore:
+		return StoreRedis
+	case *BadgerStore:
+		return StoreBadger
+	}
+
+	return "custom"
+}
@@ -0,0 +1,109 @@
+package xratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	is := require.New(t)
+
+	metrics := NewMetrics("test")
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Name:      "api",
+		Duration:  time.Second * 60,
+		Limit:     1,
+		Blacklist: []string{"blocked"},
+		Metrics:   metrics,
+	})
+
+	ctx := context.Background()
+
+	_, err := rl.Consume(ctx, "alice")
+	is.NoError(err)
+
+	_, err = rl.Consume(ctx, "alice")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+
+	_, err = rl.Consume(ctx, "blocked")
+	is.ErrorIs(err, ErrRateLimitExceeded)
+
+	_, err = rl.ConsumeN(ctx, "alice", -1)
+	is.ErrorIs(err, ErrInvalidCost)
+
+	is.Equal(float64(1), testutil.ToFloat64(metrics.decisions.WithLabelValues("api", DecisionAllowed)))
+	is.Equal(float64(2), testutil.ToFloat64(metrics.decisions.WithLabelValues("api", DecisionRejected)))
+	is.Equal(float64(1), testutil.ToFloat64(metrics.decisions.WithLabelValues("api", DecisionError)))
+
+	// memory stores update in a single transaction, the blacklist never
+	// reaches the store
+	is.Equal(1, testutil.CollectAndCount(metrics.storeDuration))
+	is.Equal(1, testutil.CollectAndCount(metrics.storeDuration.MustCurryWith(map[string]string{"backend": StoreMemory, "operation": "update"})))
+
+	expected := `
+# HELP test_ratelimit_memory_store_entries Entries held by memory stores.
+# TYPE test_ratelimit_memory_store_entries gauge
+test_ratelimit_memory_store_entries 1
+`
+	is.NoError(testutil.CollectAndCompare(metrics, strings.NewReader(expected), "test_ratelimit_memory_store_entries"))
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	is := require.New(t)
+
+	metrics := NewMetrics("")
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Name:     "web",
+		Duration: time.Second * 60,
+		Limit:    5,
+		Skip: func(rw http.ResponseWriter, r *http.Request) bool {
+			return r.URL.Path == "/health"
+		},
+		Metrics: metrics,
+	})
+
+	key := func(r *http.Request) (string, error) {
+		if r.Header.Get("X-Api-Key") == "" {
+			return "", ErrKeyNotFound
+		}
+
+		return r.Header.Get("X-Api-Key"), nil
+	}
+
+	handler := NewMiddlewareStd(rl, WithKeyStd(key)).Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	for _, target := range []string{"/items", "/health", "/items"} {
+		request := httptest.NewRequest("GET", target, nil)
+		request.Header.Set("X-Api-Key", "alice")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items", nil))
+
+	is.Equal(float64(2), testutil.ToFloat64(metrics.decisions.WithLabelValues("web", DecisionAllowed)))
+	is.Equal(float64(1), testutil.ToFloat64(metrics.decisions.WithLabelValues("web", DecisionSkipped)))
+	is.Equal(float64(1), testutil.ToFloat64(metrics.decisions.WithLabelValues("web", DecisionError)))
+}
+
+func TestStoreBackend(t *testing.T) {
+	is := require.New(t)
+
+	tiered := NewTieredStore(NewRedisStore(), WithSyncInterval(time.Hour))
+	defer tiered.Close()
+
+	local := NewTieredStore(NewMemoryStore(), WithSyncInterval(time.Hour))
+	defer local.Close()
+
+	is.Equal(StoreRedis, storeBackend(NewBreakerStore(NewRedisStore())))
+	is.Equal("tiered-redis", storeBackend(tiered))
+	is.Equal("tiered-memory", storeBackend(NewBreakerStore(local)))
+}
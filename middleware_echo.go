@@ -1,9 +1,9 @@
 package xratelimit
 
 import (
-	"context"
 	"errors"
-	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -13,6 +13,11 @@ type MiddlewareEcho struct {
 	OnError         ErrMiddlewareHandler
 	OnLimitExceeded RateLimitExceededHandler
 	IpAddress       string
+	Headers         HeaderStrategy
+	Cost            CostFunc
+	CountIf         CountFunc // responses that don't match are refunded
+	Key             KeyFunc   // derives the key from the request instead of the ip address
+	Router          *Router
 }
 
 type OptionEcho func(*MiddlewareEcho)
@@ -49,14 +54,58 @@ func WithIpAddressEcho(ip string) OptionEcho {
 	}
 }
 
+func WithHeadersEcho(strategy HeaderStrategy) OptionEcho {
+	return func(mw *MiddlewareEcho) {
+		mw.Headers = strategy
+	}
+}
+
+func WithCostEcho(cost CostFunc) OptionEcho {
+	return func(mw *MiddlewareEcho) {
+		mw.Cost = cost
+	}
+}
+
+func WithCountIfEcho(countIf CountFunc) OptionEcho {
+	return func(mw *MiddlewareEcho) {
+		mw.CountIf = countIf
+	}
+}
+
+func WithKeyEcho(key KeyFunc) OptionEcho {
+	return func(mw *MiddlewareEcho) {
+		mw.Key = key
+	}
+}
+
+func WithRouterEcho(router *Router) OptionEcho {
+	return func(mw *MiddlewareEcho) {
+		mw.Router = router
+	}
+}
+
 func (mw *MiddlewareEcho) Handler(h echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var key string
-		// ctx := c.(context.Context)
 
-		if mw.IpAddress == "" {
+		rl, keyFn := route(mw.Router, mw.RateLimit, mw.Key, c.Request())
+		if rl == nil {
+			return h(c)
+		}
+
+		if keyFn != nil {
+			k, err := keyFn(c.Request())
+			if err != nil {
+				rl.keyError(err)
+				mw.OnError(c.Response(), c.Request(), err)
+				return nil
+			}
+
+			key = k
+		} else if mw.IpAddress == "" {
 			k, err := mw.GetIp(c)
 			if err != nil {
+				rl.keyError(err)
 				mw.OnError(c.Response(), c.Request(), err)
 				return nil
 			}
@@ -66,14 +115,24 @@ func (mw *MiddlewareEcho) Handler(h echo.HandlerFunc) echo.HandlerFunc {
 			key = mw.IpAddress
 		}
 
-		if mw.RateLimit.Skip != nil && mw.RateLimit.Skip(c.Response(), c.Request()) {
+		if rl.Skip != nil && rl.Skip(c.Response(), c.Request()) {
+			rl.observe(DecisionSkipped)
 			return h(c)
 		}
 
-		_, err := mw.RateLimit.Consume(context.Background(), key)
+		n := requestCost(mw.Cost, c.Request())
+
+		res, err := rl.ConsumeN(c.Request().Context(), key, n)
+		consumedAt := time.Now()
+
+		if res != nil {
+			writeHeaders(c.Response().Header().Set, mw.Headers, res)
+		}
+
 		if err != nil {
 			if err == ErrRateLimitExceeded {
-				mw.OnLimitExceeded(c.Response(), c.Request())
+				writeRetryAfter(c.Response().Header().Set, mw.Headers, res)
+				mw.OnLimitExceeded(c.Response(), c.Request(), res)
 				return nil
 			}
 
@@ -81,21 +140,35 @@ func (mw *MiddlewareEcho) Handler(h echo.HandlerFunc) echo.HandlerFunc {
 			return nil
 		}
 
-		remaining, err := mw.RateLimit.Remaining(context.Background(), key)
-		if err != nil {
-			mw.OnError(c.Response(), c.Request(), err)
-			return nil
+		if mw.CountIf == nil {
+			return h(c)
 		}
 
-		res := c.Response()
+		err = h(c)
 
-		res.Header().Add("X-Ratelimit-Limit", fmt.Sprint(mw.RateLimit.RateLimitConfig.Limit))
-		res.Header().Add("X-Ratelimit-Remaining", fmt.Sprint(*remaining))
+		if !mw.CountIf(responseStatusEcho(c, err)) {
+			// a failed refund shouldn't replace the handler's error
+			refundResponse(c.Request().Context(), rl, key, n, res, consumedAt)
+		}
 
-		return h(c)
+		return err
 	}
 }
 
+// responseStatusEcho returns the status the response will be sent with, including
+// errors that echo's error handler writes after the middleware chain returns
+func responseStatusEcho(c echo.Context, err error) int {
+	if err == nil || c.Response().Committed {
+		return c.Response().Status
+	}
+
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
+	}
+
+	return http.StatusInternalServerError
+}
+
 func (mw *MiddlewareEcho) GetIp(ctx echo.Context) (string, error) {
 	// r := ctx.Request
 	extractor := echo.ExtractIPFromXFFHeader()
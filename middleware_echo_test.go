@@ -50,3 +50,47 @@ func TestMiddlewareEcho(t *testing.T) {
 		}
 	}
 }
+
+func TestMiddlewareEchoCountIf(t *testing.T) {
+	is := require.New(t)
+	limit := 2
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    limit,
+	})
+
+	// only failed logins count against the limit
+	countIf := func(status int) bool {
+		return status == http.StatusUnauthorized
+	}
+
+	mw := NewMiddlewareEcho(rl, WithIpAddressEcho("middleware-echo-count-ip"), WithCountIfEcho(countIf))
+
+	router := echo.New()
+	router.Use(mw.Handler)
+	router.POST("/login", func(c echo.Context) error {
+		if c.QueryParam("password") != "secret" {
+			return echo.NewHTTPError(http.StatusUnauthorized)
+		}
+
+		return c.String(http.StatusOK, "welcome")
+	})
+
+	for i := 0; i < 5; i++ {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest("POST", "/login?password=secret", nil))
+		is.Equal(http.StatusOK, resp.Code)
+	}
+
+	for i := 0; i <= limit; i++ {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest("POST", "/login?password=wrong", nil))
+
+		if i < limit {
+			is.Equal(http.StatusUnauthorized, resp.Code)
+		} else {
+			is.Equal(http.StatusTooManyRequests, resp.Code)
+		}
+	}
+}
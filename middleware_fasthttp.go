@@ -2,20 +2,25 @@ package xratelimit
 
 import (
 	"errors"
-	"fmt"
 	"net"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
 )
 
-type OnError = func(rw fasthttp.Response, r fasthttp.Request, e error)
-
 type MiddlewareFasthttp struct {
 	*RateLimit
-	OnError         interface{}
+	OnError         ErrMiddlewareHandler
 	OnLimitExceeded RateLimitExceededHandler
 	IpAddress       string
+	Headers         HeaderStrategy
+	Cost            CostFunc
+	CountIf         CountFunc // responses that don't match are refunded
+	Key             KeyFunc   // derives the key from the request instead of the ip address
+	Router          *Router
 }
 
 type OptionFasthttp func(*MiddlewareFasthttp)
@@ -52,14 +57,66 @@ func WithIpAddressFasthttp(ip string) OptionFasthttp {
 	}
 }
 
+func WithHeadersFasthttp(strategy HeaderStrategy) OptionFasthttp {
+	return func(mw *MiddlewareFasthttp) {
+		mw.Headers = strategy
+	}
+}
+
+func WithCostFasthttp(cost CostFunc) OptionFasthttp {
+	return func(mw *MiddlewareFasthttp) {
+		mw.Cost = cost
+	}
+}
+
+func WithCountIfFasthttp(countIf CountFunc) OptionFasthttp {
+	return func(mw *MiddlewareFasthttp) {
+		mw.CountIf = countIf
+	}
+}
+
+func WithKeyFasthttp(key KeyFunc) OptionFasthttp {
+	return func(mw *MiddlewareFasthttp) {
+		mw.Key = key
+	}
+}
+
+func WithRouterFasthttp(router *Router) OptionFasthttp {
+	return func(mw *MiddlewareFasthttp) {
+		mw.Router = router
+	}
+}
+
 func (mw *MiddlewareFasthttp) Handler(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		var key string
 
-		if mw.IpAddress == "" {
+		r, err := mw.request(ctx)
+		if err != nil {
+			mw.onError(ctx, err)
+			return
+		}
+
+		rl, keyFn := route(mw.Router, mw.RateLimit, mw.Key, r)
+		if rl == nil {
+			h(ctx)
+			return
+		}
+
+		if keyFn != nil {
+			k, err := keyFn(r)
+			if err != nil {
+				rl.keyError(err)
+				mw.onError(ctx, err)
+				return
+			}
+
+			key = k
+		} else if mw.IpAddress == "" {
 			k, err := mw.GetIp(ctx)
 			if err != nil {
-				mw.OnError.(OnError)(ctx.Response, ctx.Request, err)
+				rl.keyError(err)
+				mw.onError(ctx, err)
 				return
 			}
 
@@ -68,33 +125,65 @@ func (mw *MiddlewareFasthttp) Handler(h fasthttp.RequestHandler) fasthttp.Reques
 			key = mw.IpAddress
 		}
 
-		_, err := mw.RateLimit.Consume(ctx, key)
+		n := requestCost(mw.Cost, r)
+
+		res, err := rl.ConsumeN(ctx, key, n)
+		consumedAt := time.Now()
+
+		if res != nil {
+			writeHeaders(ctx.Response.Header.Set, mw.Headers, res)
+		}
+
 		if err != nil {
 			if err == ErrRateLimitExceeded {
-				// mw.OnLimitExceeded(rw, r)
+				writeRetryAfter(ctx.Response.Header.Set, mw.Headers, res)
+				mw.onLimitExceeded(ctx, res)
 				return
 			}
 
-			// mw.OnError(rw, r, err)
+			mw.onError(ctx, err)
 			return
 		}
 
-		remaining, err := mw.RateLimit.Remaining(ctx, key)
-		if err != nil {
-			// mw.OnError(rw, r, err)
-			return
+		h(ctx)
+
+		if mw.CountIf != nil && !mw.CountIf(ctx.Response.StatusCode()) {
+			refundResponse(ctx, rl, key, n, res, consumedAt)
 		}
+	}
+}
 
-		ctx.Request.Header.Add("X-Ratelimit-Limit", fmt.Sprint(mw.RateLimit.RateLimitConfig.Limit))
-		ctx.Request.Header.Add("X-Ratelimit-Remaining", fmt.Sprint(*remaining))
+// request converts the request for the net/http based router, key and cost
+// functions. It returns nil when none of them are set.
+func (mw *MiddlewareFasthttp) request(ctx *fasthttp.RequestCtx) (*http.Request, error) {
+	if mw.Router == nil && mw.Key == nil && mw.Cost == nil {
+		return nil, nil
+	}
 
-		h(ctx)
+	var r http.Request
+	if err := fasthttpadaptor.ConvertRequest(ctx, &r, true); err != nil {
+		return nil, err
 	}
+
+	return &r, nil
+}
+
+// the handlers are written against net/http, so they are run through the adaptor
+func (mw *MiddlewareFasthttp) onError(ctx *fasthttp.RequestCtx, e error) {
+	fasthttpadaptor.NewFastHTTPHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mw.OnError(rw, r, e)
+	})(ctx)
+}
+
+func (mw *MiddlewareFasthttp) onLimitExceeded(ctx *fasthttp.RequestCtx, res *Result) {
+	fasthttpadaptor.NewFastHTTPHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mw.OnLimitExceeded(rw, r, res)
+	})(ctx)
 }
 
 func (mw *MiddlewareFasthttp) GetIp(ctx *fasthttp.RequestCtx) (string, error) {
-	r := ctx.Request
-	ip := fmt.Sprint(r.Header.Peek("x-real-ip"))
+	r := &ctx.Request
+	ip := string(r.Header.Peek("x-real-ip"))
 	netIp := net.ParseIP(ip)
 	if netIp != nil {
 		return netIp.String(), nil
@@ -1 +1,59 @@
 package xratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestMiddlewareFasthttp(t *testing.T) {
+	is := require.New(t)
+	limit := 10
+	numRequests := 12
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    limit,
+	})
+
+	cost := func(r *http.Request) int {
+		if r.URL.Path == "/export" {
+			return limit + 1
+		}
+
+		return 1
+	}
+
+	mw := NewMiddlewareFasthttp(rl, WithIpAddressFasthttp("middleware-fasthttp-test-ip"), WithCostFasthttp(cost))
+
+	handler := mw.Handler(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(http.StatusOK)
+	})
+
+	serve := func(uri string) *fasthttp.RequestCtx {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI(uri)
+		handler(&ctx)
+
+		return &ctx
+	}
+
+	ctx := serve("/export")
+	is.Equal(http.StatusTooManyRequests, ctx.Response.StatusCode())
+
+	for i := 0; i < numRequests; i++ {
+		ctx = serve("/")
+
+		if i <= (limit - 1) {
+			is.Equal(http.StatusOK, ctx.Response.StatusCode())
+			is.Equal(fmt.Sprint(limit-i-1), string(ctx.Response.Header.Peek(HeaderXRateLimitRemaining)))
+		} else {
+			is.Equal(http.StatusTooManyRequests, ctx.Response.StatusCode())
+			is.NotEmpty(ctx.Response.Header.Peek(HeaderRetryAfter))
+		}
+	}
+}
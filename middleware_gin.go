@@ -1,7 +1,7 @@
 package xratelimit
 
 import (
-	"fmt"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,6 +11,11 @@ type MiddlewareGin struct {
 	OnError         ErrMiddlewareHandler
 	OnLimitExceeded RateLimitExceededHandler
 	IpAddress       string
+	Headers         HeaderStrategy
+	Cost            CostFunc
+	CountIf         CountFunc // responses that don't match are refunded
+	Key             KeyFunc   // derives the key from the request instead of the ip address
+	Router          *Router
 }
 
 type OptionGin func(*MiddlewareGin)
@@ -48,14 +53,62 @@ func WithIpAddressGin(ip string) OptionGin {
 	}
 }
 
+func WithHeadersGin(strategy HeaderStrategy) OptionGin {
+	return func(ms *MiddlewareGin) {
+		ms.Headers = strategy
+	}
+}
+
+func WithCostGin(cost CostFunc) OptionGin {
+	return func(ms *MiddlewareGin) {
+		ms.Cost = cost
+	}
+}
+
+func WithCountIfGin(countIf CountFunc) OptionGin {
+	return func(ms *MiddlewareGin) {
+		ms.CountIf = countIf
+	}
+}
+
+func WithKeyGin(key KeyFunc) OptionGin {
+	return func(ms *MiddlewareGin) {
+		ms.Key = key
+	}
+}
+
+func WithRouterGin(router *Router) OptionGin {
+	return func(ms *MiddlewareGin) {
+		ms.Router = router
+	}
+}
+
 func (mg *MiddlewareGin) Handler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var key string
 
-		if mg.IpAddress == "" {
-			k, err := mg.RateLimit.GetIp(ctx.Request)
+		rl, keyFn := route(mg.Router, mg.RateLimit, mg.Key, ctx.Request)
+		if rl == nil {
+			ctx.Next()
+			return
+		}
+
+		if keyFn != nil {
+			k, err := keyFn(ctx.Request)
 			if err != nil {
+				rl.keyError(err)
 				mg.OnError(ctx.Writer, ctx.Request, err)
+				ctx.Abort()
+				return
+			}
+
+			key = k
+		} else if mg.IpAddress == "" {
+			k, err := rl.GetIp(ctx.Request)
+			if err != nil {
+				rl.keyError(err)
+				mg.OnError(ctx.Writer, ctx.Request, err)
+				ctx.Abort()
 				return
 			}
 
@@ -64,30 +117,38 @@ func (mg *MiddlewareGin) Handler() gin.HandlerFunc {
 			key = mg.IpAddress
 		}
 
-		if mg.RateLimit.Skip != nil && mg.RateLimit.Skip(ctx.Writer, ctx.Request) {
+		if rl.Skip != nil && rl.Skip(ctx.Writer, ctx.Request) {
+			rl.observe(DecisionSkipped)
 			ctx.Next()
 			return
 		}
 
-		if _, err := mg.RateLimit.Consume(ctx, key); err != nil {
-			if err == ErrRateLimitExceeded {
-				mg.OnLimitExceeded(ctx.Writer, ctx.Request)
-				return
-			}
+		n := requestCost(mg.Cost, ctx.Request)
 
-			mg.OnError(ctx.Writer, ctx.Request, err)
-			return
+		res, err := rl.ConsumeN(ctx.Request.Context(), key, n)
+		consumedAt := time.Now()
+
+		if res != nil {
+			writeHeaders(ctx.Writer.Header().Set, mg.Headers, res)
 		}
 
-		remaining, err := mg.RateLimit.Remaining(ctx, key)
 		if err != nil {
+			if err == ErrRateLimitExceeded {
+				writeRetryAfter(ctx.Writer.Header().Set, mg.Headers, res)
+				mg.OnLimitExceeded(ctx.Writer, ctx.Request, res)
+				ctx.Abort()
+				return
+			}
+
 			mg.OnError(ctx.Writer, ctx.Request, err)
+			ctx.Abort()
 			return
 		}
 
-		ctx.Request.Header.Add("X-Ratelimit-Limit", fmt.Sprint(mg.RateLimit.RateLimitConfig.Limit))
-		ctx.Request.Header.Add("X-Ratelimit-Remaining", fmt.Sprint(remaining))
-
 		ctx.Next()
+
+		if mg.CountIf != nil && !mg.CountIf(ctx.Writer.Status()) {
+			refundResponse(ctx.Request.Context(), rl, key, n, res, consumedAt)
+		}
 	}
 }
@@ -50,3 +50,38 @@ func TestMiddlewareGin(t *testing.T) {
 		}
 	}
 }
+
+func TestMiddlewareGinCost(t *testing.T) {
+	is := require.New(t)
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    10,
+	})
+
+	cost := func(r *http.Request) int {
+		if r.URL.Path == "/export" {
+			return 5
+		}
+
+		return 1
+	}
+
+	mg := NewMiddlewareGin(rl, WithIpAddressGin("middleware-gin-cost-ip"), WithCostGin(cost))
+
+	router := gin.New()
+	router.Use(mg.Handler())
+	router.GET("/export", func(c *gin.Context) {
+		c.String(http.StatusOK, "exporting...")
+	})
+
+	codes := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+
+	for _, code := range codes {
+		resp := httptest.NewRecorder()
+
+		router.ServeHTTP(resp, httptest.NewRequest("GET", "/export", nil))
+
+		is.Equal(code, resp.Code)
+	}
+}
@@ -1,8 +1,8 @@
 package xratelimit
 
 import (
-	"fmt"
 	"net/http"
+	"time"
 )
 
 type MiddlewareStd struct {
@@ -10,6 +10,11 @@ type MiddlewareStd struct {
 	OnError         ErrMiddlewareHandler
 	OnLimitExceeded RateLimitExceededHandler
 	IpAddress       string
+	Headers         HeaderStrategy
+	Cost            CostFunc
+	CountIf         CountFunc // responses that don't match are refunded
+	Key             KeyFunc   // derives the key from the request instead of the ip address
+	Router          *Router
 }
 
 type OptionStd func(*MiddlewareStd)
@@ -47,13 +52,59 @@ func WithIpAddressStd(ip string) OptionStd {
 	}
 }
 
+func WithHeadersStd(strategy HeaderStrategy) OptionStd {
+	return func(ms *MiddlewareStd) {
+		ms.Headers = strategy
+	}
+}
+
+func WithCostStd(cost CostFunc) OptionStd {
+	return func(ms *MiddlewareStd) {
+		ms.Cost = cost
+	}
+}
+
+func WithCountIfStd(countIf CountFunc) OptionStd {
+	return func(ms *MiddlewareStd) {
+		ms.CountIf = countIf
+	}
+}
+
+func WithKeyStd(key KeyFunc) OptionStd {
+	return func(ms *MiddlewareStd) {
+		ms.Key = key
+	}
+}
+
+func WithRouterStd(router *Router) OptionStd {
+	return func(ms *MiddlewareStd) {
+		ms.Router = router
+	}
+}
+
 func (m *MiddlewareStd) Handler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		var key string
 
-		if m.IpAddress == "" {
-			k, err := m.RateLimit.GetIp(r)
+		rl, keyFn := route(m.Router, m.RateLimit, m.Key, r)
+		if rl == nil {
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		if keyFn != nil {
+			k, err := keyFn(r)
 			if err != nil {
+				rl.keyError(err)
+				m.OnError(rw, r, err)
+				return
+			}
+
+			key = k
+		} else if m.IpAddress == "" {
+			k, err := rl.GetIp(r)
+			if err != nil {
+				rl.keyError(err)
 				m.OnError(rw, r, err)
 				return
 			}
@@ -63,15 +114,25 @@ func (m *MiddlewareStd) Handler(h http.Handler) http.Handler {
 			key = m.IpAddress
 		}
 
-		if m.RateLimit.Skip != nil && m.RateLimit.Skip(rw, r) {
+		if rl.Skip != nil && rl.Skip(rw, r) {
+			rl.observe(DecisionSkipped)
 			h.ServeHTTP(rw, r)
 			return
 		}
 
-		_, err := m.RateLimit.Consume(r.Context(), key)
+		n := requestCost(m.Cost, r)
+
+		res, err := rl.ConsumeN(r.Context(), key, n)
+		consumedAt := time.Now()
+
+		if res != nil {
+			writeHeaders(rw.Header().Set, m.Headers, res)
+		}
+
 		if err != nil {
 			if err == ErrRateLimitExceeded {
-				m.OnLimitExceeded(rw, r)
+				writeRetryAfter(rw.Header().Set, m.Headers, res)
+				m.OnLimitExceeded(rw, r, res)
 				return
 			}
 
@@ -79,15 +140,16 @@ func (m *MiddlewareStd) Handler(h http.Handler) http.Handler {
 			return
 		}
 
-		remaining, err := m.RateLimit.Remaining(r.Context(), key)
-		if err != nil {
-			m.OnError(rw, r, err)
+		if m.CountIf == nil {
+			h.ServeHTTP(rw, r)
 			return
 		}
 
-		rw.Header().Add("X-Ratelimit-Limit", fmt.Sprint(m.RateLimit.RateLimitConfig.Limit))
-		rw.Header().Add("X-Ratelimit-Remaining", fmt.Sprint(*remaining))
+		sr := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		h.ServeHTTP(sr, r)
 
-		h.ServeHTTP(rw, r)
+		if !m.CountIf(sr.status) {
+			refundResponse(r.Context(), rl, key, n, res, consumedAt)
+		}
 	})
 }
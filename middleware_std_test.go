@@ -1,6 +1,7 @@
 package xratelimit
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -49,3 +50,86 @@ func TestMiddlewareStd(t *testing.T) {
 		}
 	}
 }
+
+func TestMiddlewareStdResult(t *testing.T) {
+	is := require.New(t)
+	limit := 2
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    limit,
+	})
+
+	var exceeded *Result
+	onLimitExceeded := func(rw http.ResponseWriter, r *http.Request, res *Result) {
+		exceeded = res
+		rw.WriteHeader(http.StatusTooManyRequests)
+	}
+
+	ms := NewMiddlewareStd(rl,
+		WithIpAddressStd("middleware-std-result-ip"),
+		WithHeadersStd(HeadersIETF),
+		WithOnLimitExceededStd(onLimitExceeded),
+	).Handler(handler)
+
+	for i := 0; i <= limit; i++ {
+		request := httptest.NewRequest("GET", "/", nil)
+		resp := httptest.NewRecorder()
+
+		ms.ServeHTTP(resp, request)
+
+		is.Equal(fmt.Sprint(limit), resp.Header().Get(HeaderRateLimitLimit))
+
+		if i < limit {
+			is.Equal(http.StatusOK, resp.Code)
+			is.Equal(fmt.Sprint(limit-i-1), resp.Header().Get(HeaderRateLimitRemaining))
+		} else {
+			is.Equal(http.StatusTooManyRequests, resp.Code)
+			is.Equal("60", resp.Header().Get(HeaderRetryAfter))
+		}
+	}
+
+	is.NotNil(exceeded)
+	is.False(exceeded.Allowed)
+	is.Equal("middleware-std-result-ip", exceeded.Key)
+}
+
+func TestMiddlewareStdCountIf(t *testing.T) {
+	is := require.New(t)
+
+	status := http.StatusOK
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(status)
+	})
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    1,
+	})
+
+	countIf := func(status int) bool {
+		return status >= http.StatusBadRequest
+	}
+
+	ms := NewMiddlewareStd(rl, WithIpAddressStd("middleware-std-count-ip"), WithCountIfStd(countIf)).Handler(handler)
+
+	for i := 0; i < 3; i++ {
+		resp := httptest.NewRecorder()
+		ms.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+		is.Equal(http.StatusOK, resp.Code)
+	}
+
+	status = http.StatusBadGateway
+
+	resp := httptest.NewRecorder()
+	ms.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	is.Equal(http.StatusBadGateway, resp.Code)
+
+	resp = httptest.NewRecorder()
+	ms.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	is.Equal(http.StatusTooManyRequests, resp.Code)
+}
@@ -0,0 +1,136 @@
+package xratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Observer is notified of the decisions a RateLimit makes, whichever
+// middleware is used. Observers run on the request path, wrap slow ones with
+// NewAsyncObserver. Embed NopObserver to implement only some callbacks.
+type Observer interface {
+	OnAllowed(key, policy string, res *Result)
+	// OnRejected is also called for blacklisted keys, with res.Blocked set
+	OnRejected(key, policy string, res *Result)
+	// OnStoreError is called when the key's windows can't be read or written
+	OnStoreError(key, policy string, err error)
+	OnReset(key, policy string, res *Result)
+}
+
+// NopObserver ignores every event
+type NopObserver struct{}
+
+func (NopObserver) OnAllowed(key, policy string, res *Result)  {}
+func (NopObserver) OnRejected(key, policy string, res *Result) {}
+func (NopObserver) OnStoreError(key, policy string, err error) {}
+func (NopObserver) OnReset(key, policy string, res *Result)    {}
+
+// Observers notifies every observer in order
+type Observers []Observer
+
+func (os Observers) OnAllowed(key, policy string, res *Result) {
+	for _, o := range os {
+		o.OnAllowed(key, policy, res)
+	}
+}
+
+func (os Observers) OnRejected(key, policy string, res *Result) {
+	for _, o := range os {
+		o.OnRejected(key, policy, res)
+	}
+}
+
+func (os Observers) OnStoreError(key, policy string, err error) {
+	for _, o := range os {
+		o.OnStoreError(key, policy, err)
+	}
+}
+
+func (os Observers) OnReset(key, policy string, res *Result) {
+	for _, o := range os {
+		o.OnReset(key, policy, res)
+	}
+}
+
+// AsyncObserver hands events to an Observer on a separate goroutine through a
+// buffer. Events that arrive while the buffer is full are dropped rather than
+// blocking the request.
+type AsyncObserver struct {
+	observer Observer
+	events   chan func(Observer)
+	dropped  uint64
+	done     chan struct{}
+	mu       sync.RWMutex
+	closed   bool
+}
+
+func NewAsyncObserver(observer Observer, size int) *AsyncObserver {
+	ao := &AsyncObserver{
+		observer: observer,
+		events:   make(chan func(Observer), size),
+		done:     make(chan struct{}),
+	}
+
+	go ao.run()
+
+	return ao
+}
+
+func (ao *AsyncObserver) run() {
+	defer close(ao.done)
+
+	for event := range ao.events {
+		event(ao.observer)
+	}
+}
+
+func (ao *AsyncObserver) dispatch(event func(Observer)) {
+	ao.mu.RLock()
+	defer ao.mu.RUnlock()
+
+	if ao.closed {
+		atomic.AddUint64(&ao.dropped, 1)
+		return
+	}
+
+	select {
+	case ao.events <- event:
+	default:
+		atomic.AddUint64(&ao.dropped, 1)
+	}
+}
+
+// Dropped reports the number of events dropped because the buffer was full or
+// the observer was closed
+func (ao *AsyncObserver) Dropped() uint64 {
+	return atomic.LoadUint64(&ao.dropped)
+}
+
+// Close delivers the buffered events and stops the dispatch goroutine. Events
+// sent after Close are dropped.
+func (ao *AsyncObserver) Close() {
+	ao.mu.Lock()
+	if !ao.closed {
+		ao.closed = true
+		close(ao.events)
+	}
+	ao.mu.Unlock()
+
+	<-ao.done
+}
+
+func (ao *AsyncObserver) OnAllowed(key, policy string, res *Result) {
+	ao.dispatch(func(o Observer) { o.OnAllowed(key, policy, res) })
+}
+
+func (ao *AsyncObserver) OnRejected(key, policy string, res *Result) {
+	ao.dispatch(func(o Observer) { o.OnRejected(key, policy, res) })
+}
+
+func (ao *AsyncObserver) OnStoreError(key, policy string, err error) {
+	ao.dispatch(func(o Observer) { o.OnStoreError(key, policy, err) })
+}
+
+func (ao *AsyncObserver) OnReset(key, policy string, res *Result) {
+	ao.dispatch(func(o Observer) { o.OnReset(key, policy, res) })
+}
@@ -0,0 +1,117 @@
+package xratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingObserver struct {
+	NopObserver
+	mu     sync.Mutex
+	events []string
+	block  chan struct{}
+}
+
+func (ro *recordingObserver) record(event string) {
+	if ro.block != nil {
+		<-ro.block
+	}
+
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	ro.events = append(ro.events, event)
+}
+
+func (ro *recordingObserver) OnAllowed(key, policy string, res *Result) {
+	ro.record("allowed:" + policy + ":" + key)
+}
+
+func (ro *recordingObserver) OnRejected(key, policy string, res *Result) {
+	if res.Blocked {
+		ro.record("blocked:" + key)
+		return
+	}
+
+	ro.record("rejected:" + key)
+}
+
+func (ro *recordingObserver) OnStoreError(key, policy string, err error) {
+	ro.record("error:" + key)
+}
+
+func (ro *recordingObserver) OnReset(key, policy string, res *Result) {
+	ro.record("reset:" + key)
+}
+
+func TestObserver(t *testing.T) {
+	is := require.New(t)
+
+	first, second := &recordingObserver{}, &recordingObserver{}
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Name:      "api",
+		Duration:  time.Second * 60,
+		Limit:     1,
+		Blacklist: []string{"mallory"},
+		Observer:  Observers{first, second},
+	})
+
+	ctx := context.Background()
+
+	rl.Consume(ctx, "alice")
+	rl.Consume(ctx, "alice")
+	rl.Consume(ctx, "mallory")
+	rl.Reset(ctx, "alice")
+
+	want := []string{"allowed:api:alice", "rejected:alice", "blocked:mallory", "reset:alice"}
+	is.Equal(want, first.events)
+	is.Equal(want, second.events)
+
+	observer := &recordingObserver{}
+	rl = New(failingStore{NewMemoryStore()}, RateLimitConfig{Duration: time.Second, Limit: 1, Observer: observer})
+
+	_, err := rl.Consume(ctx, "alice")
+	is.Error(err)
+	is.Equal([]string{"error:alice"}, observer.events)
+}
+
+func TestAsyncObserver(t *testing.T) {
+	is := require.New(t)
+
+	observer := &recordingObserver{block: make(chan struct{})}
+	async := NewAsyncObserver(observer, 2)
+
+	rl := New(NewMemoryStore(), RateLimitConfig{Duration: time.Second * 60, Limit: 10, Observer: async})
+
+	// the first event is picked up and blocks the observer, two more fill the
+	// buffer and the rest are dropped without blocking Consume
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 6; i++ {
+			rl.Consume(context.Background(), "alice")
+			if i == 0 {
+				time.Sleep(time.Millisecond * 20)
+			}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Consume blocked on a slow observer")
+	}
+
+	is.Equal(uint64(3), async.Dropped())
+
+	close(observer.block)
+	async.Close()
+	is.Len(observer.events, 3)
+
+	rl.Consume(context.Background(), "alice")
+	is.Equal(uint64(4), async.Dropped())
+}
@@ -3,24 +3,66 @@ package xratelimit
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RateLimitConfig struct {
+	Name      string // policy name reported in results, prefixes its keys in the store, must not contain ':'
 	Duration  time.Duration
 	Limit     int
+	Calendar  Calendar                                           // aligns windows to the calendar, replaces Duration
+	Rules     []Rule                                             // replaces Duration and Limit when set
+	Levels    []Level                                            // outer tiers of a hierarchical policy
+	Limits    LimitProvider                                      // per key limits, Limit is the default for keys it doesn't know
 	Skip      func(rw http.ResponseWriter, r *http.Request) bool // cond for a request to be skipped
 	Whitelist []string                                           // whitelisted ips
+	Blacklist []string                                           // keys that are always rejected
+	Metrics   *Metrics                                           // optional Prometheus metrics
+	Tracer    trace.Tracer                                       // optional OpenTelemetry tracer
+	Observer  Observer                                           // notified of every decision
+	Logging   *Logging                                           // logs store errors and rejections
+	Failover  Failover                                           // what Consume does when the store fails
+	Lease     Lease                                              // reserve units in batches instead of per request
+}
+
+// Rule is a single limit applied to a key, e.g. 10 per second. A key is
+// tracked separately for every rule, under the rule's name, calendar or
+// duration.
+//
+// Calendar rules keep their counters for up to a whole period, so the store's
+// ttl must be at least as long as the period.
+type Rule struct {
+	Name     string
+	Duration time.Duration
+	Limit    int
+	Calendar Calendar // aligns windows to the calendar, replaces Duration
 }
 
 type RateLimit struct {
 	RateLimitConfig
 	Store
-	m sync.Mutex
+	m         sync.Mutex
+	health    storeHealth
+	local     *RateLimit
+	initOnce  sync.Once
+	leases    leases
+	overrides overrides
+}
+
+// Limiter is implemented by RateLimit and by the RemoteLimit of a Client, so
+// callers can switch between an embedded and a remote limiter
+type Limiter interface {
+	Consume(ctx context.Context, key string) (*Result, error)
+	ConsumeN(ctx context.Context, key string, n int) (*Result, error)
+	Peek(ctx context.Context, key string) (*Result, error)
+	Reset(ctx context.Context, key string) (*Result, error)
 }
 
 type RequestLog struct {
@@ -28,94 +70,558 @@ type RequestLog struct {
 	Counter   int
 }
 
+// Result describes the state of a key's window after a call to Consume. When
+// several rules apply it reports the most restrictive one.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAt    time.Time
+	RetryAfter time.Duration // zero when the request was allowed
+	Window     time.Duration
+	Key        string
+	Policy     string
+	Blocked    bool // rejected because the key is blacklisted
+	Degraded   bool // decided by the Failover mode because the store failed
+}
+
+// bucket is the store entry tracking one rule for one key
+type bucket struct {
+	key  string
+	rule Rule
+	log  *RequestLog
+}
+
 func New(store Store, config RateLimitConfig) *RateLimit {
+	if config.Metrics != nil {
+		config.Metrics.watch(store)
+	}
+
 	return &RateLimit{
 		RateLimitConfig: config,
 		Store:           store,
 	}
 }
 
-func (rl *RateLimit) Consume(ctx context.Context, key string) (*RequestLog, error) {
-	rl.m.Lock()
-	defer rl.m.Unlock()
+// Consume records a request for key and reports the resulting state of its
+// window. When the limit has been reached the result is returned along with
+// ErrRateLimitExceeded.
+func (rl *RateLimit) Consume(ctx context.Context, key string) (*Result, error) {
+	return rl.ConsumeN(ctx, key, 1)
+}
 
-	var payload RequestLog
+// ConsumeN is like Consume but records a request costing n units. A request
+// whose cost exceeds what is left under any rule is rejected and nothing is
+// consumed.
+func (rl *RateLimit) ConsumeN(ctx context.Context, key string, n int) (*Result, error) {
+	ctx, span := rl.startSpan(ctx, "ratelimit.Consume", attrPolicy.String(rl.RateLimitConfig.Name), attrCost.Int(n))
+
+	res, err := rl.consumeN(ctx, key, n)
+	if span != nil {
+		endConsumeSpan(span, res, err)
+	}
+
+	switch {
+	case err == nil:
+		rl.observe(DecisionAllowed)
+
+		if rl.Observer != nil {
+			rl.Observer.OnAllowed(key, rl.RateLimitConfig.Name, res)
+		}
+	case errors.Is(err, ErrRateLimitExceeded):
+		rl.observe(DecisionRejected)
+
+		if rl.Observer != nil {
+			rl.Observer.OnRejected(key, rl.RateLimitConfig.Name, res)
+		}
+
+		rl.Logging.log(LogRejected, "rate limit exceeded", "policy", rl.RateLimitConfig.Name, "key", key, "limit", res.Limit, "retry_after", res.RetryAfter, "blocked", res.Blocked)
+	default:
+		rl.observe(DecisionError)
+	}
+
+	return res, err
+}
+
+func (rl *RateLimit) consumeN(ctx context.Context, key string, n int) (*Result, error) {
+	if n < 0 {
+		return nil, ErrInvalidCost
+	}
+
+	now := time.Now()
 
 	if rl.isWhitelistedIp(key) {
-		return nil, nil
+		return rl.fullResult(rl.defaultBuckets(key), key, now), nil
 	}
 
-	rlog, err := rl.Store.GetItem(ctx, key)
+	if rl.isBlacklisted(key) {
+		return rl.blockedResult(rl.defaultBuckets(key), key, now), ErrRateLimitExceeded
+	}
+
+	buckets, err := rl.buckets(ctx, key)
 	if err != nil {
-		// check nil error
-		if strings.Split(err.Error(), ": ")[1] != "nil" {
-			return nil, err
+		rl.storeError(key, err)
+		return nil, err
+	}
+
+	if !rl.storeAvailable(now) {
+		return rl.degraded(ctx, key, n, buckets, now)
+	}
+
+	if rl.Lease.enabled() {
+		return rl.consumeLeased(ctx, key, n, buckets, now)
+	}
+
+	res, exceeded, err := rl.consume(ctx, key, n, buckets, now)
+	if err != nil {
+		rl.storeError(key, err)
+		return rl.storeFailed(ctx, key, n, buckets, now, err)
+	}
+
+	rl.storeRecovered()
+
+	if exceeded {
+		return res, ErrRateLimitExceeded
+	}
+
+	return res, nil
+}
+
+// consume adds n to every bucket unless one would exceed its limit, in a
+// single store call when the store is an IncrStore
+func (rl *RateLimit) consume(ctx context.Context, key string, n int, buckets []*bucket, now time.Time) (res *Result, exceeded bool, err error) {
+	if _, ok := rl.Store.(IncrStore); ok {
+		res, exceeded, err = rl.increment(ctx, key, n, buckets, now)
+		if !errors.Is(err, ErrNotSupported) {
+			return res, exceeded, err
 		}
+	}
 
-		payload.Timestamp = time.Now()
-		payload.Counter = 1
+	err = rl.update(ctx, buckets, now, func(buckets []*bucket) bool {
+		var rejected []*Result
 
-		if err := rl.Store.SetItem(ctx, key, &payload); err != nil {
-			return nil, err
+		for _, b := range buckets {
+			if b.log.Counter+n > b.rule.Limit {
+				rejected = append(rejected, rl.result(key, b, false))
+			}
 		}
 
-		rlog, err := rl.Store.GetItem(ctx, key)
-		if err != nil {
-			return nil, err
+		if len(rejected) > 0 {
+			res, exceeded = mostRestrictive(rejected), true
+			return false
+		}
+
+		results := make([]*Result, 0, len(buckets))
+
+		for _, b := range buckets {
+			b.log.Counter += n
+			results = append(results, rl.result(key, b, true))
 		}
 
-		return rlog, nil
+		res, exceeded = mostRestrictive(results), false
+		return true
+	})
+
+	return res, exceeded, err
+}
+
+func (rl *RateLimit) increment(ctx context.Context, key string, n int, buckets []*bucket, now time.Time) (*Result, bool, error) {
+	incs := make([]Increment, len(buckets))
+	for i, b := range buckets {
+		since, until, start := rl.bounds(b, now)
+		incs[i] = Increment{Key: b.key, Since: since, Until: until, Start: start, Limit: b.rule.Limit}
 	}
 
-	if time.Since(rlog.Timestamp) >= rl.RateLimitConfig.Duration {
-		// Reset counter
-		return rl.Reset(ctx, key)
+	logs, ok, err := rl.incrementItems(ctx, incs, n)
+	if err != nil {
+		return nil, false, err
 	}
 
-	if rlog.Counter >= rl.RateLimitConfig.Limit {
-		return nil, ErrRateLimitExceeded
+	var results []*Result
+
+	for i, b := range buckets {
+		b.log = logs[i]
+
+		if ok || b.log.Counter+n > b.rule.Limit {
+			results = append(results, rl.result(key, b, ok))
+		}
 	}
 
-	payload.Timestamp = rlog.Timestamp
-	payload.Counter = rlog.Counter + 1
+	return mostRestrictive(results), !ok, nil
+}
 
-	if err := rl.Store.SetItem(ctx, key, &payload); err != nil {
-		return nil, err
+// Peek reports the current state of key's window without consuming from it.
+// Keys that have not been seen yet report the full allowance.
+func (rl *RateLimit) Peek(ctx context.Context, key string) (*Result, error) {
+	now := time.Now()
+
+	if rl.isWhitelistedIp(key) {
+		return rl.fullResult(rl.defaultBuckets(key), key, now), nil
+	}
+
+	if rl.isBlacklisted(key) {
+		return rl.blockedResult(rl.defaultBuckets(key), key, now), nil
 	}
 
-	rlog, err = rl.Store.GetItem(ctx, key)
+	buckets, err := rl.buckets(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	return rlog, nil
+	if err := rl.load(ctx, buckets, now); err != nil {
+		return nil, err
+	}
+
+	results := make([]*Result, 0, len(buckets))
+
+	for _, b := range buckets {
+		// units leased by this instance are still available to it
+		b.log.Counter -= rl.unspent(b)
+		results = append(results, rl.result(key, b, b.log.Counter < b.rule.Limit))
+	}
+
+	return mostRestrictive(results), nil
 }
 
+// Remaining returns the number of requests left in the current window for key
 func (rl *RateLimit) Remaining(ctx context.Context, key string) (*int, error) {
-	rlog, err := rl.Store.GetItem(ctx, key)
+	res, err := rl.Peek(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	return &rlog.Counter, nil
+	return &res.Remaining, nil
+}
+
+// Refund gives n units back to key's current window, for requests that
+// turned out not to count. The counter never drops below zero.
+func (rl *RateLimit) Refund(ctx context.Context, key string, n int) (*Result, error) {
+	return rl.refund(ctx, key, n, time.Time{})
 }
 
-func (rl *RateLimit) Reset(ctx context.Context, key string) (*RequestLog, error) {
-	var payload RequestLog
+// refund skips windows that started after since, so units consumed in an
+// earlier window are not credited to a new one. A zero since refunds the
+// current windows.
+func (rl *RateLimit) refund(ctx context.Context, key string, n int, since time.Time) (*Result, error) {
+	if n < 0 {
+		return nil, ErrInvalidCost
+	}
+
+	now := time.Now()
+
+	if rl.isWhitelistedIp(key) {
+		return rl.fullResult(rl.defaultBuckets(key), key, now), nil
+	}
+
+	buckets, err := rl.buckets(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var res *Result
+
+	err = rl.update(ctx, buckets, now, func(buckets []*bucket) bool {
+		results := make([]*Result, 0, len(buckets))
+		changed := false
+
+		for _, b := range buckets {
+			if b.log.Counter > 0 && (since.IsZero() || !b.log.Timestamp.After(since)) {
+				b.log.Counter -= n
+				if b.log.Counter < 0 {
+					b.log.Counter = 0
+				}
+
+				changed = true
+			}
+
+			results = append(results, rl.result(key, b, b.log.Counter < b.rule.Limit))
+		}
+
+		res = mostRestrictive(results)
+		return changed
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
 
-	payload.Timestamp = time.Now()
-	payload.Counter = 1
+// Reset clears every window tracked for key
+func (rl *RateLimit) Reset(ctx context.Context, key string) (*Result, error) {
+	rl.m.Lock()
+	defer rl.m.Unlock()
 
-	if err := rl.Store.SetItem(ctx, key, &payload); err != nil {
+	buckets, err := rl.buckets(ctx, key)
+	if err != nil {
 		return nil, err
 	}
 
-	rlog, err := rl.Store.GetItem(ctx, key)
+	rl.dropLeases(buckets)
+
+	for _, b := range buckets {
+		if err := rl.deleteItem(ctx, b.key); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			rl.storeError(key, err)
+			return nil, err
+		}
+	}
+
+	res := rl.fullResult(buckets, key, time.Now())
+
+	if rl.Observer != nil {
+		rl.Observer.OnReset(key, rl.RateLimitConfig.Name, res)
+	}
+
+	return res, nil
+}
+
+func (rl *RateLimit) rules() []Rule {
+	if len(rl.RateLimitConfig.Rules) > 0 {
+		return rl.RateLimitConfig.Rules
+	}
+
+	return []Rule{{Duration: rl.RateLimitConfig.Duration, Limit: rl.RateLimitConfig.Limit, Calendar: rl.RateLimitConfig.Calendar}}
+}
+
+// buckets lists the store entries tracking key, with the key's own limit
+// resolved through the LimitProvider when one is set
+func (rl *RateLimit) buckets(ctx context.Context, key string) ([]*bucket, error) {
+	buckets := rl.defaultBuckets(key)
+
+	if len(rl.RateLimitConfig.Rules) > 0 {
+		return buckets, nil
+	}
+
+	if limit, ok := rl.overriddenLimit(key, time.Now()); ok {
+		buckets[0].rule.Limit = limit
+		return buckets, nil
+	}
+
+	if rl.Limits == nil {
+		return buckets, nil
+	}
+
+	limit, ok, err := rl.Limits.Limit(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	return rlog, nil
+	if ok {
+		buckets[0].rule.Limit = limit
+	}
+
+	return buckets, nil
+}
+
+// defaultBuckets lists the store entries tracking key with the configured
+// limits: one per rule, plus one per rule of every level
+func (rl *RateLimit) defaultBuckets(key string) []*bucket {
+	var buckets []*bucket
+
+	if len(rl.RateLimitConfig.Rules) == 0 {
+		buckets = []*bucket{{key: rl.storeKey(key), rule: rl.rules()[0]}}
+	} else {
+		buckets = ruleBuckets(rl.storeKey(key), rl.RateLimitConfig.Rules)
+	}
+
+	for _, level := range rl.RateLimitConfig.Levels {
+		buckets = append(buckets, ruleBuckets(rl.prefixKey(levelPrefix+level.key(key)), level.Rules)...)
+	}
+
+	return buckets
+}
+
+// storeKey returns the store key of a request key. Keys starting with '@'
+// get another one, so no request key can start with levelPrefix and share a
+// level's counter.
+func (rl *RateLimit) storeKey(key string) string {
+	if strings.HasPrefix(key, "@") {
+		key = "@" + key
+	}
+
+	return rl.prefixKey(key)
+}
+
+// requestKey reverses storeKey for a key stripped of the policy's name
+func requestKey(key string) string {
+	if strings.HasPrefix(key, "@@") {
+		return key[1:]
+	}
+
+	return key
+}
+
+// prefixKey prefixes key with the policy's name, so policies sharing a store
+// don't share counters
+func (rl *RateLimit) prefixKey(key string) string {
+	if rl.RateLimitConfig.Name == "" {
+		return key
+	}
+
+	return fmt.Sprintf("%s:%s", rl.RateLimitConfig.Name, key)
+}
+
+// load reads buckets from the store, starting new windows for buckets that
+// are missing or expired
+func (rl *RateLimit) load(ctx context.Context, buckets []*bucket, now time.Time) error {
+	for _, b := range buckets {
+		rlog, err := rl.getItem(ctx, b.key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+
+		b.log = rl.window(b, rlog, now)
+	}
+
+	return nil
+}
+
+func (rl *RateLimit) window(b *bucket, rlog *RequestLog, now time.Time) *RequestLog {
+	since, until, start := rl.bounds(b, now)
+
+	if rlog == nil || rlog.Timestamp.Before(since) || (!until.IsZero() && !rlog.Timestamp.Before(until)) {
+		// start a new window
+		return &RequestLog{Timestamp: start}
+	}
+
+	return rlog
+}
+
+// bounds returns the timestamps a log of b's current window can have, and
+// the start of a new window
+func (rl *RateLimit) bounds(b *bucket, now time.Time) (since, until, start time.Time) {
+	if b.rule.Calendar != nil {
+		// calendar windows start at the boundary, not at the first request
+		start, end := b.rule.Calendar.Window(now)
+		return start, end, start
+	}
+
+	return now.Add(-b.rule.Duration + 1), time.Time{}, now
+}
+
+// update loads buckets, lets fn modify them and writes them back when fn
+// returns true. The read-modify-write is atomic across processes for stores
+// implementing TxStore, and atomic within this RateLimit for other stores.
+func (rl *RateLimit) update(ctx context.Context, buckets []*bucket, now time.Time, fn func(buckets []*bucket) bool) error {
+	if _, ok := rl.Store.(TxStore); ok {
+		keys := make([]string, len(buckets))
+		for i, b := range buckets {
+			keys[i] = b.key
+		}
+
+		return rl.updateItems(ctx, keys, func(logs []*RequestLog) ([]*RequestLog, error) {
+			for i, b := range buckets {
+				b.log = rl.window(b, logs[i], now)
+			}
+
+			if !fn(buckets) {
+				return nil, nil
+			}
+
+			logs = make([]*RequestLog, len(buckets))
+			for i, b := range buckets {
+				logs[i] = b.log
+			}
+
+			return logs, nil
+		})
+	}
+
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	if err := rl.load(ctx, buckets, now); err != nil {
+		return err
+	}
+
+	if !fn(buckets) {
+		return nil
+	}
+
+	for _, b := range buckets {
+		if err := rl.setItem(ctx, b.key, b.log); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rl *RateLimit) result(key string, b *bucket, allowed bool) *Result {
+	remaining := b.rule.Limit - b.log.Counter
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	resetAt, window := b.log.Timestamp.Add(b.rule.Duration), b.rule.Duration
+	if b.rule.Calendar != nil {
+		start, end := b.rule.Calendar.Window(b.log.Timestamp)
+		resetAt, window = end, end.Sub(start)
+	}
+
+	res := &Result{
+		Allowed:   allowed,
+		Limit:     b.rule.Limit,
+		Remaining: remaining,
+		ResetAt:   resetAt,
+		Window:    window,
+		Key:       key,
+		Policy:    rl.RateLimitConfig.Name,
+	}
+
+	if !allowed {
+		res.RetryAfter = time.Until(res.ResetAt)
+		if res.RetryAfter < 0 {
+			res.RetryAfter = 0
+		}
+	}
+
+	return res
+}
+
+// fullResult reports the untouched allowance of the most restrictive bucket
+func (rl *RateLimit) fullResult(buckets []*bucket, key string, now time.Time) *Result {
+	results := make([]*Result, 0, len(buckets))
+
+	for _, b := range buckets {
+		b.log = rl.window(b, nil, now)
+		results = append(results, rl.result(key, b, true))
+	}
+
+	return mostRestrictive(results)
+}
+
+// blockedResult reports a blacklisted key as having nothing left
+func (rl *RateLimit) blockedResult(buckets []*bucket, key string, now time.Time) *Result {
+	res := rl.fullResult(buckets, key, now)
+	res.Allowed = false
+	res.Remaining = 0
+	res.RetryAfter = res.Window
+	res.Blocked = true
+
+	return res
+}
+
+// mostRestrictive picks the result a client should act on: the one that keeps
+// it waiting longest when rejected, otherwise the one with the least remaining
+func mostRestrictive(results []*Result) *Result {
+	res := results[0]
+
+	for _, r := range results[1:] {
+		switch {
+		case r.RetryAfter != res.RetryAfter:
+			if r.RetryAfter > res.RetryAfter {
+				res = r
+			}
+		case r.Remaining < res.Remaining:
+			res = r
+		case r.Remaining == res.Remaining && r.ResetAt.After(res.ResetAt):
+			res = r
+		}
+	}
+
+	return res
 }
 
 func (rl *RateLimit) GetIp(r *http.Request) (string, error) {
@@ -157,3 +663,98 @@ func (rl *RateLimit) isWhitelistedIp(ip string) bool {
 
 	return false
 }
+
+func (rl *RateLimit) isBlacklisted(key string) bool {
+	for _, v := range rl.Blacklist {
+		if strings.EqualFold(v, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// observe counts a decision when metrics are enabled
+func (rl *RateLimit) observe(decision string) {
+	if rl.Metrics != nil {
+		rl.Metrics.decision(rl.RateLimitConfig.Name, decision)
+	}
+}
+
+// storeError reports a failure to read or write key's windows
+func (rl *RateLimit) storeError(key string, err error) {
+	if rl.Observer != nil {
+		rl.Observer.OnStoreError(key, rl.RateLimitConfig.Name, err)
+	}
+
+	rl.Logging.log(LogStoreError, "rate limit store error", "policy", rl.RateLimitConfig.Name, "key", key, "error", err)
+}
+
+// keyError reports a middleware failing to resolve a request's key
+func (rl *RateLimit) keyError(err error) {
+	rl.observe(DecisionError)
+	rl.Logging.log(LogMiddlewareError, "rate limit key error", "policy", rl.RateLimitConfig.Name, "error", err)
+}
+
+// getItem, setItem, deleteItem, updateItems and incrementItems call the store, timing and
+// tracing the calls when metrics or tracing are enabled
+
+func (rl *RateLimit) getItem(ctx context.Context, key string) (rlog *RequestLog, err error) {
+	ctx, done := rl.storeOp(ctx, "get")
+	defer func() { done(err) }()
+
+	return rl.Store.GetItem(ctx, key)
+}
+
+func (rl *RateLimit) setItem(ctx context.Context, key string, rlog *RequestLog) (err error) {
+	ctx, done := rl.storeOp(ctx, "set")
+	defer func() { done(err) }()
+
+	return rl.Store.SetItem(ctx, key, rlog)
+}
+
+func (rl *RateLimit) deleteItem(ctx context.Context, key string) (err error) {
+	ctx, done := rl.storeOp(ctx, "delete")
+	defer func() { done(err) }()
+
+	return rl.Store.DeleteItem(ctx, key)
+}
+
+func (rl *RateLimit) updateItems(ctx context.Context, keys []string, fn UpdateFunc) (err error) {
+	ctx, done := rl.storeOp(ctx, "update")
+	defer func() { done(err) }()
+
+	return rl.Store.(TxStore).UpdateItems(ctx, keys, fn)
+}
+
+func (rl *RateLimit) incrementItems(ctx context.Context, incs []Increment, n int) (logs []*RequestLog, ok bool, err error) {
+	ctx, done := rl.storeOp(ctx, "increment")
+	defer func() { done(err) }()
+
+	return rl.Store.(IncrStore).IncrementItems(ctx, incs, n)
+}
+
+func (rl *RateLimit) storeOp(ctx context.Context, operation string) (context.Context, func(err error)) {
+	if rl.Metrics == nil && rl.Tracer == nil {
+		return ctx, func(error) {}
+	}
+
+	start := time.Now()
+	ctx, span := rl.startSpan(ctx, "ratelimit.store."+operation, attrStore.String(storeBackend(rl.Store)))
+
+	return ctx, func(err error) {
+		if rl.Metrics != nil {
+			rl.Metrics.storeOp(rl.Store, operation, start)
+		}
+
+		if span == nil {
+			return
+		}
+
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			endSpanError(span, err)
+		}
+
+		span.End()
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestConsume(t *testing.T) {
@@ -40,3 +42,207 @@ func TestConsume(t *testing.T) {
 		}
 	})
 }
+
+func TestConsumeResult(t *testing.T) {
+	is := require.New(t)
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Name:     "test-policy",
+		Duration: time.Second * 60,
+		Limit:    3,
+	})
+
+	key := "consume-result-key"
+
+	for i := 1; i <= 3; i++ {
+		res, err := rl.Consume(context.Background(), key)
+		is.NoError(err)
+		is.True(res.Allowed)
+		is.Equal(3, res.Limit)
+		is.Equal(3-i, res.Remaining)
+		is.Equal(key, res.Key)
+		is.Equal("test-policy", res.Policy)
+		is.Zero(res.RetryAfter)
+	}
+
+	res, err := rl.Consume(context.Background(), key)
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.False(res.Allowed)
+	is.Equal(0, res.Remaining)
+	is.True(res.RetryAfter > 0)
+	is.WithinDuration(time.Now().Add(time.Second*60), res.ResetAt, time.Second)
+
+	remaining, err := rl.Remaining(context.Background(), key)
+	is.NoError(err)
+	is.Equal(0, *remaining)
+}
+
+func TestConsumeN(t *testing.T) {
+	is := require.New(t)
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    10,
+	})
+
+	key := "consume-n-key"
+
+	res, err := rl.ConsumeN(context.Background(), key, 7)
+	is.NoError(err)
+	is.Equal(3, res.Remaining)
+
+	// cost exceeds what is left, nothing should be consumed
+	res, err = rl.ConsumeN(context.Background(), key, 4)
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.False(res.Allowed)
+	is.Equal(3, res.Remaining)
+
+	res, err = rl.ConsumeN(context.Background(), key, 3)
+	is.NoError(err)
+	is.Equal(0, res.Remaining)
+
+	_, err = rl.ConsumeN(context.Background(), key, -1)
+	is.ErrorIs(err, ErrInvalidCost)
+}
+
+func TestPeek(t *testing.T) {
+	is := require.New(t)
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    2,
+	})
+
+	key := "peek-key"
+
+	res, err := rl.Peek(context.Background(), key)
+	is.NoError(err)
+	is.True(res.Allowed)
+	is.Equal(2, res.Remaining)
+
+	_, err = rl.Store.GetItem(context.Background(), key)
+	is.ErrorIs(err, ErrKeyNotFound)
+
+	_, err = rl.ConsumeN(context.Background(), key, 2)
+	is.NoError(err)
+
+	for i := 0; i < 2; i++ {
+		res, err = rl.Peek(context.Background(), key)
+		is.NoError(err)
+		is.False(res.Allowed)
+		is.Equal(0, res.Remaining)
+		is.True(res.RetryAfter > 0)
+	}
+
+	remaining, err := rl.Remaining(context.Background(), "unseen-key")
+	is.NoError(err)
+	is.Equal(2, *remaining)
+}
+
+func TestRefund(t *testing.T) {
+	is := require.New(t)
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    5,
+	})
+
+	key := "refund-key"
+
+	_, err := rl.ConsumeN(context.Background(), key, 4)
+	is.NoError(err)
+
+	res, err := rl.Refund(context.Background(), key, 3)
+	is.NoError(err)
+	is.Equal(4, res.Remaining)
+
+	res, err = rl.Refund(context.Background(), key, 10)
+	is.NoError(err)
+	is.Equal(5, res.Remaining)
+
+	// refunds for a window that has already reset are ignored
+	_, err = rl.ConsumeN(context.Background(), key, 2)
+	is.NoError(err)
+
+	res, err = rl.refund(context.Background(), key, 2, time.Now().Add(-time.Second))
+	is.NoError(err)
+	is.Equal(3, res.Remaining)
+}
+
+func TestConsumeRules(t *testing.T) {
+	is := require.New(t)
+
+	store := NewMemoryStore()
+	rl := New(store, RateLimitConfig{
+		Rules: []Rule{
+			{Name: "burst", Duration: time.Second * 60, Limit: 3},
+			{Name: "quota", Duration: time.Hour, Limit: 5},
+		},
+	})
+
+	key := "rules-key"
+
+	for i := 0; i < 3; i++ {
+		res, err := rl.Consume(context.Background(), key)
+		is.NoError(err)
+		is.Equal(3, res.Limit)
+		is.Equal(2-i, res.Remaining)
+	}
+
+	// the burst rule rejects, so the quota must not be consumed
+	res, err := rl.Consume(context.Background(), key)
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.Equal(3, res.Limit)
+	is.Equal(time.Second*60, res.Window)
+
+	quota, err := store.GetItem(context.Background(), key+":quota")
+	is.NoError(err)
+	is.Equal(3, quota.Counter)
+
+	// once the burst window resets the quota becomes the tighter rule
+	store.SetItem(context.Background(), key+":burst", &RequestLog{Timestamp: time.Now().Add(-time.Minute)})
+
+	res, err = rl.Consume(context.Background(), key)
+	is.NoError(err)
+	is.Equal(5, res.Limit)
+	is.Equal(1, res.Remaining)
+
+	res, err = rl.ConsumeN(context.Background(), key, 2)
+	is.ErrorIs(err, ErrRateLimitExceeded)
+	is.Equal(5, res.Limit)
+	is.Equal(time.Hour, res.Window)
+
+	res, err = rl.Reset(context.Background(), key)
+	is.NoError(err)
+	is.Equal(3, res.Remaining)
+
+	_, err = store.GetItem(context.Background(), key+":quota")
+	is.ErrorIs(err, ErrKeyNotFound)
+}
+
+func TestPolicyKeyPrefix(t *testing.T) {
+	is := require.New(t)
+
+	store := NewMemoryStore()
+	api := New(store, RateLimitConfig{Name: "api", Duration: time.Minute, Limit: 1, Levels: []Level{{Name: "global", Rules: []Rule{{Duration: time.Minute, Limit: 10}}}}})
+	web := New(store, RateLimitConfig{Name: "web", Duration: time.Minute, Limit: 1})
+	ctx := context.Background()
+
+	res, err := api.Consume(ctx, "alice")
+	is.NoError(err)
+	is.Equal("alice", res.Key)
+
+	_, err = web.Consume(ctx, "alice")
+	is.NoError(err)
+
+	keys, _, err := store.Scan(ctx, "", "", 0)
+	is.NoError(err)
+	is.Equal([]string{"api:@:global:1m0s", "api:alice", "web:alice"}, keys)
+
+	_, err = api.Reset(ctx, "alice")
+	is.NoError(err)
+
+	rlog, err := store.GetItem(ctx, "web:alice")
+	is.NoError(err)
+	is.Equal(1, rlog.Counter)
+}
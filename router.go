@@ -0,0 +1,185 @@
+package xratelimit
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type RouteMode int
+
+const (
+	FirstMatch   RouteMode = iota // the first matching route in declaration order wins
+	MostSpecific                  // the matching route with the most specific path, then host, then method wins
+)
+
+// Route maps the requests it matches to a RateLimit. Empty matchers match
+// every request, and all set matchers must match.
+type Route struct {
+	Methods    []string
+	Host       string         // compared without the port
+	PathPrefix string         // e.g. "/api/"
+	PathGlob   string         // path.Match pattern, e.g. "/users/*/export"
+	PathRegexp *regexp.Regexp // e.g. regexp.MustCompile(`^/v[0-9]+/search`)
+	RateLimit  *RateLimit
+	Key        KeyFunc // overrides the middleware's key when set
+}
+
+// Router picks the policy applied to a request. Requests matching no route
+// fall back to the middleware's own RateLimit.
+type Router struct {
+	Mode   RouteMode
+	Routes []Route
+	mu     sync.RWMutex
+}
+
+func NewRouter(mode RouteMode, routes ...Route) *Router {
+	return &Router{
+		Mode:   mode,
+		Routes: routes,
+	}
+}
+
+// Replace swaps in the routes of other, so middlewares holding rt pick up a
+// new set of policies atomically
+func (rt *Router) Replace(other *Router) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.Mode = other.Mode
+	rt.Routes = other.Routes
+}
+
+// Match returns the route for r, or nil if no route matches
+func (rt *Router) Match(r *http.Request) *Route {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	return rt.match(r)
+}
+
+func (rt *Router) match(r *http.Request) *Route {
+	var match *Route
+	score := -1
+
+	for i := range rt.Routes {
+		route := &rt.Routes[i]
+
+		if !route.matches(r) {
+			continue
+		}
+
+		if rt.Mode == FirstMatch {
+			return route
+		}
+
+		if s := route.specificity(); s > score {
+			match, score = route, s
+		}
+	}
+
+	return match
+}
+
+// resolve returns the RateLimit and key function that apply to r
+func (rt *Router) resolve(r *http.Request) (*RateLimit, KeyFunc) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	match := rt.match(r)
+	if match == nil {
+		return nil, nil
+	}
+
+	return match.RateLimit, match.Key
+}
+
+func (route *Route) matches(r *http.Request) bool {
+	if len(route.Methods) > 0 && !containsFold(route.Methods, r.Method) {
+		return false
+	}
+
+	if route.Host != "" && !strings.EqualFold(route.Host, requestHost(r)) {
+		return false
+	}
+
+	p := r.URL.Path
+
+	if route.PathPrefix != "" && !strings.HasPrefix(p, route.PathPrefix) {
+		return false
+	}
+
+	if route.PathGlob != "" {
+		if ok, _ := path.Match(route.PathGlob, p); !ok {
+			return false
+		}
+	}
+
+	if route.PathRegexp != nil && !route.PathRegexp.MatchString(p) {
+		return false
+	}
+
+	return true
+}
+
+// specificity ranks by the literal length of the path matchers, with host and
+// method breaking ties
+func (route *Route) specificity() int {
+	literal := len(route.PathPrefix)
+
+	if route.PathGlob != "" {
+		literal += len(route.PathGlob) - strings.Count(route.PathGlob, "*") - strings.Count(route.PathGlob, "?")
+	}
+
+	if route.PathRegexp != nil {
+		literal += len(regexpPrefix(route.PathRegexp))
+	}
+
+	score := literal * 4
+
+	if route.Host != "" {
+		score += 2
+	}
+
+	if len(route.Methods) > 0 {
+		score++
+	}
+
+	return score
+}
+
+// regexpPrefix returns the literal text every match starts with, looking past
+// a leading ^ which LiteralPrefix doesn't
+func regexpPrefix(re *regexp.Regexp) string {
+	expr := re.String()
+	if strings.HasPrefix(expr, "^") {
+		if anchored, err := regexp.Compile(expr[1:]); err == nil {
+			re = anchored
+		}
+	}
+
+	prefix, _ := re.LiteralPrefix()
+	return prefix
+}
+
+func requestHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return r.Host
+	}
+
+	return host
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package xratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterMatch(t *testing.T) {
+	is := require.New(t)
+
+	routes := []Route{
+		{PathPrefix: "/api/"},
+		{PathPrefix: "/api/", Methods: []string{"POST"}},
+		{PathGlob: "/api/users/*/export"},
+		{PathRegexp: regexp.MustCompile(`^/api/v[0-9]+/search`)},
+		{Host: "admin.example.com"},
+	}
+
+	cases := []struct {
+		method, target  string
+		first, specific int
+	}{
+		{"GET", "/api/items", 0, 0},
+		{"POST", "/api/items", 0, 1},
+		{"GET", "/api/users/7/export", 0, 2},
+		{"GET", "/api/v2/search", 0, 3},
+		{"GET", "http://admin.example.com:8080/dashboard", 4, 4},
+		{"GET", "/health", -1, -1},
+	}
+
+	for _, mode := range []RouteMode{FirstMatch, MostSpecific} {
+		router := NewRouter(mode, routes...)
+
+		for _, c := range cases {
+			want := c.first
+			if mode == MostSpecific {
+				want = c.specific
+			}
+
+			match := router.Match(httptest.NewRequest(c.method, c.target, nil))
+
+			if want < 0 {
+				is.Nil(match, "%s %s", c.method, c.target)
+			} else {
+				is.Same(&router.Routes[want], match, "%s %s", c.method, c.target)
+			}
+		}
+	}
+}
+
+func TestMiddlewareStdRouter(t *testing.T) {
+	is := require.New(t)
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	store := NewMemoryStore()
+	fallback := New(store, RateLimitConfig{Name: "default", Duration: time.Second * 60, Limit: 3})
+	search := New(store, RateLimitConfig{Name: "search", Duration: time.Second * 60, Limit: 1})
+
+	router := NewRouter(FirstMatch, Route{
+		Methods:    []string{"GET"},
+		PathPrefix: "/search",
+		RateLimit:  search,
+		Key: func(r *http.Request) (string, error) {
+			return "search:" + r.Header.Get("X-User"), nil
+		},
+	})
+
+	ms := NewMiddlewareStd(fallback, WithIpAddressStd("middleware-std-router-ip"), WithRouterStd(router)).Handler(handler)
+
+	serve := func(target, user string) int {
+		request := httptest.NewRequest("GET", target, nil)
+		request.Header.Set("X-User", user)
+		resp := httptest.NewRecorder()
+		ms.ServeHTTP(resp, request)
+
+		return resp.Code
+	}
+
+	is.Equal(http.StatusOK, serve("/search?q=a", "alice"))
+	is.Equal(http.StatusTooManyRequests, serve("/search?q=b", "alice"))
+	is.Equal(http.StatusOK, serve("/search?q=a", "bob"))
+
+	for i := 0; i < 3; i++ {
+		is.Equal(http.StatusOK, serve("/items", "alice"))
+	}
+
+	is.Equal(http.StatusTooManyRequests, serve("/items", "alice"))
+}
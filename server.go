@@ -0,0 +1,140 @@
+package xratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrUnknownPolicy is returned for a policy a Server doesn't have
+var ErrUnknownPolicy = errors.New("unknown rate limit policy")
+
+// Server hosts RateLimits for other services. It serves an HTTP JSON API
+// under /v1/consume, /v1/peek and /v1/reset, each taking a POST body such as
+// {"policy": "api", "key": "alice", "cost": 1}, and the gRPC API described in
+// proto/ratelimit.proto.
+//
+// Rejected requests are answered with 429 Too Many Requests over HTTP and with
+// allowed set to false over gRPC, along with the result either way.
+type Server struct {
+	lookup func(policy string) *RateLimit
+}
+
+// NewServer serves the RateLimits lookup returns by policy name, e.g.
+// Policies.Policy. lookup returns nil for unknown policies.
+func NewServer(lookup func(policy string) *RateLimit) *Server {
+	return &Server{lookup: lookup}
+}
+
+const (
+	opConsume = "consume"
+	opPeek    = "peek"
+	opReset   = "reset"
+)
+
+type serverRequest struct {
+	Policy string `json:"policy"`
+	Key    string `json:"key"`
+	Cost   *int   `json:"cost,omitempty"` // 1 when unset
+}
+
+type serverResult struct {
+	Allowed      bool      `json:"allowed"`
+	Limit        int       `json:"limit"`
+	Remaining    int       `json:"remaining"`
+	ResetAt      time.Time `json:"reset_at"`
+	RetryAfterMs int64     `json:"retry_after_ms"`
+	WindowMs     int64     `json:"window_ms"`
+	Key          string    `json:"key"`
+	Policy       string    `json:"policy"`
+	Blocked      bool      `json:"blocked,omitempty"`
+	Degraded     bool      `json:"degraded,omitempty"`
+}
+
+type serverError struct {
+	Error string `json:"error"`
+}
+
+// call runs op for req, returning the result along with ErrRateLimitExceeded
+// for rejected requests
+func (s *Server) call(ctx context.Context, op string, req serverRequest) (*Result, error) {
+	rl := s.lookup(req.Policy)
+	if rl == nil {
+		return nil, ErrUnknownPolicy
+	}
+
+	switch op {
+	case opConsume:
+		cost := 1
+		if req.Cost != nil {
+			cost = *req.Cost
+		}
+
+		return rl.ConsumeN(ctx, req.Key, cost)
+	case opPeek:
+		return rl.Peek(ctx, req.Key)
+	}
+
+	return rl.Reset(ctx, req.Key)
+}
+
+func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	op := strings.TrimPrefix(r.URL.Path, "/v1/")
+	known := op == opConsume || op == opPeek || op == opReset
+
+	switch {
+	case !known:
+		writeJSON(rw, http.StatusNotFound, serverError{Error: "not found"})
+		return
+	case r.Method != http.MethodPost:
+		rw.Header().Set("Allow", http.MethodPost)
+		writeJSON(rw, http.StatusMethodNotAllowed, serverError{Error: "method not allowed"})
+		return
+	}
+
+	var req serverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(rw, http.StatusBadRequest, serverError{Error: err.Error()})
+		return
+	}
+
+	res, err := s.call(r.Context(), op, req)
+
+	switch {
+	case errors.Is(err, ErrRateLimitExceeded):
+		writeRetryAfter(rw.Header().Set, HeadersXRateLimit, res)
+		writeJSON(rw, http.StatusTooManyRequests, newServerResult(res))
+	case errors.Is(err, ErrUnknownPolicy):
+		writeJSON(rw, http.StatusNotFound, serverError{Error: err.Error()})
+	case errors.Is(err, ErrInvalidCost):
+		writeJSON(rw, http.StatusBadRequest, serverError{Error: err.Error()})
+	case err != nil:
+		writeJSON(rw, http.StatusInternalServerError, serverError{Error: err.Error()})
+	default:
+		writeJSON(rw, http.StatusOK, newServerResult(res))
+	}
+}
+
+func newServerResult(res *Result) serverResult {
+	return serverResult{
+		Allowed:      res.Allowed,
+		Limit:        res.Limit,
+		Remaining:    res.Remaining,
+		ResetAt:      res.ResetAt,
+		RetryAfterMs: res.RetryAfter.Milliseconds(),
+		WindowMs:     res.Window.Milliseconds(),
+		Key:          res.Key,
+		Policy:       res.Policy,
+		Blocked:      res.Blocked,
+		Degraded:     res.Degraded,
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,219 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const grpcServiceName = "xratelimit.v1.RateLimiter"
+
+// rateLimiterFile describes proto/ratelimit.proto. Its messages are used as
+// dynamic messages rather than generated code, so they are encoded by the
+// default proto codec like any other.
+var rateLimiterFile = func() protoreflect.FileDescriptor {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   typ.Enum(),
+		}
+	}
+
+	const (
+		typeString = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		typeInt64  = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		typeBool   = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	)
+
+	cost := field("cost", 3, typeInt64)
+	cost.OneofIndex, cost.Proto3Optional = proto.Int32(0), proto.Bool(true)
+
+	method := func(name string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".xratelimit.v1.RateLimitRequest"),
+			OutputType: proto.String(".xratelimit.v1.RateLimitResponse"),
+		}
+	}
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("proto/ratelimit.proto"),
+		Package: proto.String("xratelimit.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("RateLimitRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("policy", 1, typeString),
+					field("key", 2, typeString),
+					cost,
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("_cost")}},
+			},
+			{
+				Name: proto.String("RateLimitResponse"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("allowed", 1, typeBool),
+					field("limit", 2, typeInt64),
+					field("remaining", 3, typeInt64),
+					field("reset_at_unix_ms", 4, typeInt64),
+					field("retry_after_ms", 5, typeInt64),
+					field("window_ms", 6, typeInt64),
+					field("key", 7, typeString),
+					field("policy", 8, typeString),
+					field("blocked", 9, typeBool),
+					field("degraded", 10, typeBool),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name:   proto.String("RateLimiter"),
+			Method: []*descriptorpb.MethodDescriptorProto{method("Consume"), method("Peek"), method("Reset")},
+		}},
+	}, new(protoregistry.Files))
+	if err != nil {
+		panic(err)
+	}
+
+	return file
+}()
+
+var (
+	grpcRequestType  = rateLimiterFile.Messages().ByName("RateLimitRequest")
+	grpcResponseType = rateLimiterFile.Messages().ByName("RateLimitResponse")
+)
+
+// GRPCServer creates a grpc.Server serving the RateLimiter service. Other
+// services can be registered on it as well.
+func (s *Server) GRPCServer(options ...grpc.ServerOption) *grpc.Server {
+	g := grpc.NewServer(options...)
+	g.RegisterService(&grpc.ServiceDesc{
+		ServiceName: grpcServiceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "Consume", Handler: s.grpcHandler("Consume", opConsume)},
+			{MethodName: "Peek", Handler: s.grpcHandler("Peek", opPeek)},
+			{MethodName: "Reset", Handler: s.grpcHandler("Reset", opReset)},
+		},
+		Metadata: "proto/ratelimit.proto",
+	}, s)
+
+	return g
+}
+
+func (s *Server) grpcHandler(method, op string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := dynamicpb.NewMessage(grpcRequestType)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+
+		handle := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.grpcCall(ctx, op, req.(*dynamicpb.Message))
+		}
+
+		if interceptor == nil {
+			return handle(ctx, req)
+		}
+
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcServiceName + "/" + method}
+		return interceptor(ctx, req, info, handle)
+	}
+}
+
+func (s *Server) grpcCall(ctx context.Context, op string, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+	fields := grpcRequestType.Fields()
+	sreq := serverRequest{
+		Policy: req.Get(fields.ByName("policy")).String(),
+		Key:    req.Get(fields.ByName("key")).String(),
+	}
+
+	if fd := fields.ByName("cost"); req.Has(fd) {
+		cost := int(req.Get(fd).Int())
+		sreq.Cost = &cost
+	}
+
+	res, err := s.call(ctx, op, sreq)
+
+	switch {
+	case err == nil, errors.Is(err, ErrRateLimitExceeded):
+		return newGRPCResponse(res), nil
+	case errors.Is(err, ErrUnknownPolicy):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrInvalidCost):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return nil, status.Error(codes.Unavailable, err.Error())
+}
+
+// newGRPCRequest builds a RateLimitRequest, cost is left unset when nil
+func newGRPCRequest(policy, key string, cost *int64) *dynamicpb.Message {
+	fields := grpcRequestType.Fields()
+
+	m := dynamicpb.NewMessage(grpcRequestType)
+	m.Set(fields.ByName("policy"), protoreflect.ValueOfString(policy))
+	m.Set(fields.ByName("key"), protoreflect.ValueOfString(key))
+
+	if cost != nil {
+		m.Set(fields.ByName("cost"), protoreflect.ValueOfInt64(*cost))
+	}
+
+	return m
+}
+
+func newGRPCResponse(res *Result) *dynamicpb.Message {
+	fields := grpcResponseType.Fields()
+	m := dynamicpb.NewMessage(grpcResponseType)
+
+	set := func(name string, v protoreflect.Value) {
+		m.Set(fields.ByName(protoreflect.Name(name)), v)
+	}
+
+	set("allowed", protoreflect.ValueOfBool(res.Allowed))
+	set("limit", protoreflect.ValueOfInt64(int64(res.Limit)))
+	set("remaining", protoreflect.ValueOfInt64(int64(res.Remaining)))
+	set("reset_at_unix_ms", protoreflect.ValueOfInt64(res.ResetAt.UnixNano()/int64(time.Millisecond)))
+	set("retry_after_ms", protoreflect.ValueOfInt64(res.RetryAfter.Milliseconds()))
+	set("window_ms", protoreflect.ValueOfInt64(res.Window.Milliseconds()))
+	set("key", protoreflect.ValueOfString(res.Key))
+	set("policy", protoreflect.ValueOfString(res.Policy))
+	set("blocked", protoreflect.ValueOfBool(res.Blocked))
+	set("degraded", protoreflect.ValueOfBool(res.Degraded))
+
+	return m
+}
+
+// grpcResult converts a RateLimitResponse
+func grpcResult(m *dynamicpb.Message) *Result {
+	fields := grpcResponseType.Fields()
+
+	get := func(name string) protoreflect.Value {
+		return m.Get(fields.ByName(protoreflect.Name(name)))
+	}
+
+	return &Result{
+		Allowed:    get("allowed").Bool(),
+		Limit:      int(get("limit").Int()),
+		Remaining:  int(get("remaining").Int()),
+		ResetAt:    time.Unix(0, get("reset_at_unix_ms").Int()*int64(time.Millisecond)),
+		RetryAfter: time.Duration(get("retry_after_ms").Int()) * time.Millisecond,
+		Window:     time.Duration(get("window_ms").Int()) * time.Millisecond,
+		Key:        get("key").String(),
+		Policy:     get("policy").String(),
+		Blocked:    get("blocked").Bool(),
+		Degraded:   get("degraded").Bool(),
+	}
+}
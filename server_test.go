@@ -0,0 +1,81 @@
+package xratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testServer() *Server {
+	limits := map[string]*RateLimit{
+		"api": New(NewMemoryStore(), RateLimitConfig{Name: "api", Duration: time.Minute, Limit: 3, Blacklist: []string{"mallory"}}),
+	}
+
+	return NewServer(func(policy string) *RateLimit {
+		return limits[policy]
+	})
+}
+
+func TestServerHTTP(t *testing.T) {
+	is := require.New(t)
+
+	server := testServer()
+
+	post := func(path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rw := httptest.NewRecorder()
+		server.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+
+		var out map[string]interface{}
+		is.NoError(json.Unmarshal(rw.Body.Bytes(), &out))
+
+		return rw, out
+	}
+
+	rw, out := post("/v1/consume", `{"policy": "api", "key": "alice", "cost": 2}`)
+	is.Equal(http.StatusOK, rw.Code)
+	is.Equal(true, out["allowed"])
+	is.Equal(float64(1), out["remaining"])
+	is.Equal(float64(60000), out["window_ms"])
+	is.Equal("api", out["policy"])
+
+	rw, out = post("/v1/peek", `{"policy": "api", "key": "alice"}`)
+	is.Equal(http.StatusOK, rw.Code)
+	is.Equal(float64(1), out["remaining"])
+
+	post("/v1/consume", `{"policy": "api", "key": "alice"}`)
+
+	rw, out = post("/v1/consume", `{"policy": "api", "key": "alice"}`)
+	is.Equal(http.StatusTooManyRequests, rw.Code)
+	is.Equal(false, out["allowed"])
+	is.NotEmpty(rw.Header().Get(HeaderRetryAfter))
+
+	rw, out = post("/v1/consume", `{"policy": "api", "key": "mallory"}`)
+	is.Equal(http.StatusTooManyRequests, rw.Code)
+	is.Equal(true, out["blocked"])
+
+	rw, out = post("/v1/reset", `{"policy": "api", "key": "alice"}`)
+	is.Equal(http.StatusOK, rw.Code)
+	is.Equal(float64(3), out["remaining"])
+
+	rw, out = post("/v1/consume", `{"policy": "web", "key": "alice"}`)
+	is.Equal(http.StatusNotFound, rw.Code)
+	is.Equal(ErrUnknownPolicy.Error(), out["error"])
+
+	rw, _ = post("/v1/consume", `{"policy": "api", "key": "alice", "cost": -1}`)
+	is.Equal(http.StatusBadRequest, rw.Code)
+
+	rw, _ = post("/v1/consume", `{"policy":`)
+	is.Equal(http.StatusBadRequest, rw.Code)
+
+	rw, _ = post("/v1/refund", `{"policy": "api", "key": "alice"}`)
+	is.Equal(http.StatusNotFound, rw.Code)
+
+	rw = httptest.NewRecorder()
+	server.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/v1/peek", nil))
+	is.Equal(http.StatusMethodNotAllowed, rw.Code)
+}
@@ -1,9 +1,88 @@
 package xratelimit
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrKeyNotFound is returned by a Store's GetItem and DeleteItem when no item exists for key
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrTxConflict is returned by a TxStore when an update kept conflicting with concurrent writers
+	ErrTxConflict = errors.New("transaction conflicted with concurrent updates")
+	// ErrNotSupported is returned by stores wrapping a store that lacks an optional capability
+	ErrNotSupported = errors.New("operation not supported by store")
+)
+
+// DefaultNamespace prefixes every key a store writes unless set otherwise
+const DefaultNamespace = "x-ratelimit"
+
+// TxRetries is how many times a TxStore retries a conflicting update
+const TxRetries = 10
+
+// ScanCount is the number of keys a Scan returns when count is zero
+const ScanCount = 100
 
 type Store interface {
 	GetItem(ctx context.Context, key string) (*RequestLog, error)
 	SetItem(ctx context.Context, key string, payload *RequestLog) error
 	DeleteItem(ctx context.Context, key string) error
 }
+
+// UpdateFunc receives the current logs for a set of keys (nil for missing
+// keys) and returns the logs to write in their place. Returning a nil slice
+// writes nothing, and a nil entry leaves that key untouched. It may be called
+// more than once when the update is retried.
+type UpdateFunc = func(logs []*RequestLog) ([]*RequestLog, error)
+
+// TxStore is implemented by stores that can read and update several items as
+// one atomic operation, which RateLimit uses to keep limits consistent across
+// processes sharing the store.
+type TxStore interface {
+	Store
+	UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error
+}
+
+// Increment is the current window of one key for an IncrStore. A stored log
+// whose timestamp falls outside [Since, Until) has expired and counts as a new
+// window starting at Start.
+type Increment struct {
+	Key   string
+	Since time.Time
+	Until time.Time // no upper bound when zero
+	Start time.Time
+	Limit int
+}
+
+// IncrStore is implemented by stores that can check and increment several
+// counters as one operation that never conflicts, e.g. with a script running
+// on the store
+type IncrStore interface {
+	Store
+	// IncrementItems adds n to the window of every key when none would exceed
+	// its limit, and returns the windows with ok set. Otherwise nothing is
+	// written and the current windows are returned.
+	IncrementItems(ctx context.Context, incs []Increment, n int) (logs []*RequestLog, ok bool, err error)
+}
+
+// ScanStore is implemented by stores that can list their keys
+type ScanStore interface {
+	Store
+	// Scan returns up to about count keys starting with prefix, and the cursor
+	// to pass for the next page, empty after the last page. Keys are returned
+	// as passed to SetItem, without the store's namespace. Keys written during
+	// a scan may or may not be returned.
+	Scan(ctx context.Context, prefix, cursor string, count int) (keys []string, next string, err error)
+}
+
+// BulkStore is implemented by stores that can count and delete keys by
+// prefix, e.g. to clear a namespace
+type BulkStore interface {
+	ScanStore
+	// DeleteByPrefix deletes every key starting with prefix, returning how many
+	// were deleted
+	DeleteByPrefix(ctx context.Context, prefix string) (int, error)
+	// Count returns the number of keys starting with prefix
+	Count(ctx context.Context, prefix string) (int, error)
+}
@@ -26,22 +26,22 @@ type OptionBadger func(*BadgerStore)
 func NewBadgerStore(options ...OptionBadger) (*BadgerStore, error) {
 	bs := &BadgerStore{
 		client:    nil,
-		namespace: "x-ratelimit",
+		namespace: DefaultNamespace,
 		ttl:       time.Second * BadgerTTL,
 		path:      BadgerPath,
 	}
 
-	db, err := badger.Open(badger.DefaultOptions(BadgerPath))
+	for _, opt := range options {
+		opt(bs)
+	}
+
+	db, err := badger.Open(badger.DefaultOptions(bs.path))
 	if err != nil {
 		return nil, err
 	}
 
 	bs.client = db
 
-	for _, opt := range options {
-		opt(bs)
-	}
-
 	return bs, nil
 }
 
@@ -51,6 +51,17 @@ func WithPath(path string) OptionBadger {
 	}
 }
 
+// WithNamespaceBadger sets the prefix of the store's keys
+func WithNamespaceBadger(namespace string) OptionBadger {
+	return func(bs *BadgerStore) {
+		bs.namespace = namespace
+	}
+}
+
+func (s *BadgerStore) Close() error {
+	return s.client.Close()
+}
+
 func (s *BadgerStore) GetItem(ctx context.Context, key string) (*RequestLog, error) {
 	var log *RequestLog
 	var copy []byte
@@ -59,6 +70,10 @@ func (s *BadgerStore) GetItem(ctx context.Context, key string) (*RequestLog, err
 	err := s.client.View(func(txn *badger.Txn) error {
 		v, err := txn.Get([]byte(key))
 		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrKeyNotFound
+			}
+
 			return err
 		}
 
@@ -111,12 +126,79 @@ func (s *BadgerStore) SetItem(ctx context.Context, key string, payload *RequestL
 	return nil
 }
 
+// UpdateItems runs fn in a read-write transaction, retrying when badger
+// detects a conflict with a concurrent transaction
+func (s *BadgerStore) UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error {
+	for i := 0; i < TxRetries; i++ {
+		err := s.client.Update(func(txn *badger.Txn) error {
+			logs := make([]*RequestLog, len(keys))
+
+			for i, key := range keys {
+				item, err := txn.Get([]byte(fmt.Sprintf("%s:%s", s.namespace, key)))
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+
+				if err != nil {
+					return err
+				}
+
+				err = item.Value(func(val []byte) error {
+					return json.Unmarshal(val, &logs[i])
+				})
+
+				if err != nil {
+					return err
+				}
+			}
+
+			logs, err := fn(logs)
+			if err != nil {
+				return err
+			}
+
+			for i, log := range logs {
+				if log == nil {
+					continue
+				}
+
+				b, err := json.Marshal(log)
+				if err != nil {
+					return err
+				}
+
+				entry := badger.NewEntry([]byte(fmt.Sprintf("%s:%s", s.namespace, keys[i])), b)
+
+				if err := txn.SetEntry(entry); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+
+	return ErrTxConflict
+}
+
 func (s *BadgerStore) DeleteItem(ctx context.Context, key string) error {
 	key = fmt.Sprintf("%s:%s", s.namespace, key)
 
 	txn := s.client.NewTransaction(true)
 	defer txn.Discard()
 
+	if _, err := txn.Get([]byte(key)); err != nil {
+		if err == badger.ErrKeyNotFound {
+			return ErrKeyNotFound
+		}
+
+		return err
+	}
+
 	if err := txn.Delete([]byte(key)); err != nil {
 		return err
 	}
@@ -127,3 +209,107 @@ func (s *BadgerStore) DeleteItem(ctx context.Context, key string) error {
 
 	return nil
 }
+
+// Scan pages through keys in order, the cursor is the last key returned
+func (s *BadgerStore) Scan(ctx context.Context, prefix, cursor string, count int) ([]string, string, error) {
+	if count <= 0 {
+		count = ScanCount
+	}
+
+	var keys []string
+	var next string
+	ns := s.namespace + ":"
+
+	err := s.client.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(ns + prefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		start := opts.Prefix
+		if cursor != "" {
+			start = []byte(ns + cursor)
+		}
+
+		for it.Seek(start); it.Valid(); it.Next() {
+			key := string(it.Item().Key()[len(ns):])
+			if cursor != "" && key == cursor {
+				continue
+			}
+
+			if len(keys) == count {
+				next = keys[count-1]
+				return nil
+			}
+
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return keys, next, nil
+}
+
+// DeleteByPrefix deletes the keys in batches, keys written meanwhile may be
+// left
+func (s *BadgerStore) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	var keys [][]byte
+
+	err := s.iterate(prefix, func(key []byte) {
+		keys = append(keys, key)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	wb := s.client.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
+
+func (s *BadgerStore) Count(ctx context.Context, prefix string) (int, error) {
+	var count int
+
+	err := s.iterate(prefix, func(key []byte) {
+		count++
+	})
+
+	return count, err
+}
+
+// iterate calls fn with a copy of every namespaced key starting with prefix
+func (s *BadgerStore) iterate(prefix string, fn func(key []byte)) error {
+	return s.client.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(fmt.Sprintf("%s:%s", s.namespace, prefix))
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			fn(it.Item().KeyCopy(nil))
+		}
+
+		return nil
+	})
+}
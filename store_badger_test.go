@@ -39,3 +39,16 @@ func TestGetSetItem(t *testing.T) {
 		badger.client.Close()
 	})
 }
+
+func TestBadgerStoreScan(t *testing.T) {
+	badger, err := NewBadgerStore(WithPath(t.TempDir()))
+	require.NoError(t, err)
+	defer badger.Close()
+
+	testScanStore(t, badger)
+
+	_, err = badger.DeleteByPrefix(context.Background(), "")
+	require.NoError(t, err)
+
+	testBulkStore(t, badger)
+}
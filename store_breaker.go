@@ -0,0 +1,310 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerTimeout   = time.Millisecond * 100
+	BreakerThreshold = 5
+	BreakerCooldown  = time.Second * 5
+)
+
+// ErrCircuitOpen is returned by a BreakerStore while its circuit is open
+var ErrCircuitOpen = errors.New("store circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // calls go through
+	BreakerOpen                         // calls fail fast with ErrCircuitOpen
+	BreakerHalfOpen                     // a single call probes the store
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+
+	return "closed"
+}
+
+// BreakerStore wraps any Store with a per-operation timeout and a circuit
+// breaker. After threshold consecutive failures the circuit opens and calls
+// fail fast with ErrCircuitOpen, which a RateLimit's Failover handles like any
+// other store error. After the cooldown a single call probes the store and
+// closes the circuit again when it succeeds.
+//
+// Timeouts are applied through the context, so the wrapped store must honour
+// it, as RedisStore does. UpdateItems is atomic only when the wrapped store is
+// a TxStore, otherwise it is serialised by the BreakerStore.
+type BreakerStore struct {
+	store     Store
+	timeout   time.Duration
+	threshold int
+	cooldown  time.Duration
+	onChange  func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	update   sync.Mutex
+}
+
+type OptionBreaker func(*BreakerStore)
+
+func NewBreakerStore(store Store, options ...OptionBreaker) *BreakerStore {
+	bs := &BreakerStore{
+		store:     store,
+		timeout:   BreakerTimeout,
+		threshold: BreakerThreshold,
+		cooldown:  BreakerCooldown,
+	}
+
+	for _, option := range options {
+		option(bs)
+	}
+
+	return bs
+}
+
+// WithTimeout limits every store call, no limit when zero
+func WithTimeout(timeout time.Duration) OptionBreaker {
+	return func(bs *BreakerStore) {
+		bs.timeout = timeout
+	}
+}
+
+// WithThreshold sets the consecutive failures that open the circuit
+func WithThreshold(threshold int) OptionBreaker {
+	return func(bs *BreakerStore) {
+		bs.threshold = threshold
+	}
+}
+
+// WithCooldown sets how long the circuit stays open before a probe
+func WithCooldown(cooldown time.Duration) OptionBreaker {
+	return func(bs *BreakerStore) {
+		bs.cooldown = cooldown
+	}
+}
+
+// WithOnStateChange is called, with the breaker's lock held, on every change
+// of state
+func WithOnStateChange(fn func(from, to BreakerState)) OptionBreaker {
+	return func(bs *BreakerStore) {
+		bs.onChange = fn
+	}
+}
+
+// State reports the state of the circuit
+func (bs *BreakerStore) State() BreakerState {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	return bs.state
+}
+
+func (bs *BreakerStore) GetItem(ctx context.Context, key string) (rlog *RequestLog, err error) {
+	err = bs.call(ctx, func(ctx context.Context) error {
+		rlog, err = bs.store.GetItem(ctx, key)
+		return err
+	})
+
+	return rlog, err
+}
+
+func (bs *BreakerStore) SetItem(ctx context.Context, key string, payload *RequestLog) error {
+	return bs.call(ctx, func(ctx context.Context) error {
+		return bs.store.SetItem(ctx, key, payload)
+	})
+}
+
+func (bs *BreakerStore) DeleteItem(ctx context.Context, key string) error {
+	return bs.call(ctx, func(ctx context.Context) error {
+		return bs.store.DeleteItem(ctx, key)
+	})
+}
+
+func (bs *BreakerStore) UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error {
+	return bs.call(ctx, func(ctx context.Context) error {
+		if _, ok := bs.store.(TxStore); !ok {
+			bs.update.Lock()
+			defer bs.update.Unlock()
+		}
+
+		return updateItems(ctx, bs.store, keys, fn)
+	})
+}
+
+// IncrementItems returns ErrNotSupported when the wrapped store isn't an
+// IncrStore
+func (bs *BreakerStore) IncrementItems(ctx context.Context, incs []Increment, n int) (logs []*RequestLog, ok bool, err error) {
+	is, supported := bs.store.(IncrStore)
+	if !supported {
+		return nil, false, ErrNotSupported
+	}
+
+	err = bs.call(ctx, func(ctx context.Context) error {
+		logs, ok, err = is.IncrementItems(ctx, incs, n)
+		return err
+	})
+
+	return logs, ok, err
+}
+
+// Scan returns ErrNotSupported when the wrapped store isn't a ScanStore
+func (bs *BreakerStore) Scan(ctx context.Context, prefix, cursor string, count int) (keys []string, next string, err error) {
+	ss, ok := bs.store.(ScanStore)
+	if !ok {
+		return nil, "", ErrNotSupported
+	}
+
+	err = bs.call(ctx, func(ctx context.Context) error {
+		keys, next, err = ss.Scan(ctx, prefix, cursor, count)
+		return err
+	})
+
+	return keys, next, err
+}
+
+// DeleteByPrefix returns ErrNotSupported when the wrapped store isn't a
+// BulkStore
+func (bs *BreakerStore) DeleteByPrefix(ctx context.Context, prefix string) (deleted int, err error) {
+	bulk, ok := bs.store.(BulkStore)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	err = bs.call(ctx, func(ctx context.Context) error {
+		deleted, err = bulk.DeleteByPrefix(ctx, prefix)
+		return err
+	})
+
+	return deleted, err
+}
+
+// Count returns ErrNotSupported when the wrapped store isn't a BulkStore
+func (bs *BreakerStore) Count(ctx context.Context, prefix string) (count int, err error) {
+	bulk, ok := bs.store.(BulkStore)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	err = bs.call(ctx, func(ctx context.Context) error {
+		count, err = bulk.Count(ctx, prefix)
+		return err
+	})
+
+	return count, err
+}
+
+func (bs *BreakerStore) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !bs.allow(time.Now()) {
+		return ErrCircuitOpen
+	}
+
+	opCtx := ctx
+	if bs.timeout > 0 {
+		var cancel context.CancelFunc
+		opCtx, cancel = context.WithTimeout(ctx, bs.timeout)
+		defer cancel()
+	}
+
+	err := fn(opCtx)
+
+	// requests cancelled by the caller say nothing about the store, missing
+	// keys and conflicts are answers from a working one
+	if err != nil && ctx.Err() != nil {
+		bs.abandon()
+		return err
+	}
+
+	failed := err != nil && !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrTxConflict)
+	bs.done(failed, time.Now())
+
+	return err
+}
+
+func (bs *BreakerStore) allow(now time.Time) bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	switch bs.state {
+	case BreakerOpen:
+		if now.Sub(bs.openedAt) < bs.cooldown {
+			return false
+		}
+
+		bs.setState(BreakerHalfOpen)
+		bs.probing = true
+
+		return true
+	case BreakerHalfOpen:
+		if bs.probing {
+			return false
+		}
+
+		bs.probing = true
+	}
+
+	return true
+}
+
+func (bs *BreakerStore) done(failed bool, now time.Time) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.state == BreakerHalfOpen {
+		bs.probing = false
+	}
+
+	if !failed {
+		bs.failures = 0
+
+		if bs.state != BreakerClosed {
+			bs.setState(BreakerClosed)
+		}
+
+		return
+	}
+
+	bs.failures++
+
+	if bs.state == BreakerHalfOpen || bs.failures >= bs.threshold {
+		bs.openedAt = now
+		bs.setState(BreakerOpen)
+	}
+}
+
+// abandon releases the probe of a half-open circuit without changing its
+// state, so the next call probes the store again
+func (bs *BreakerStore) abandon() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.state == BreakerHalfOpen {
+		bs.probing = false
+	}
+}
+
+func (bs *BreakerStore) setState(state BreakerState) {
+	if bs.state == state {
+		return
+	}
+
+	from := bs.state
+	bs.state = state
+
+	if bs.onChange != nil {
+		bs.onChange(from, state)
+	}
+}
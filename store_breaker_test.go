@@ -0,0 +1,172 @@
+package xratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// slowStore is a Store whose calls take delay unless ctx is done first
+type slowStore struct {
+	Store
+	delay time.Duration
+}
+
+func (ss slowStore) GetItem(ctx context.Context, key string) (*RequestLog, error) {
+	select {
+	case <-time.After(ss.delay):
+		return ss.Store.GetItem(ctx, key)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestBreakerStore(t *testing.T) {
+	is := require.New(t)
+
+	flaky := &flakyStore{Store: NewMemoryStore()}
+	flaky.setDown(true)
+
+	var changes []string
+	bs := NewBreakerStore(flaky, WithThreshold(3), WithCooldown(time.Millisecond*50), WithOnStateChange(func(from, to BreakerState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}))
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := bs.GetItem(ctx, "alice")
+		is.ErrorIs(err, errStoreDown)
+	}
+
+	is.Equal(BreakerOpen, bs.State())
+
+	_, err := bs.GetItem(ctx, "alice")
+	is.ErrorIs(err, ErrCircuitOpen)
+	is.Equal(int32(3), atomic.LoadInt32(&flaky.calls))
+
+	// a failed probe opens the circuit again
+	time.Sleep(time.Millisecond * 60)
+	_, err = bs.GetItem(ctx, "alice")
+	is.ErrorIs(err, errStoreDown)
+	is.Equal(BreakerOpen, bs.State())
+
+	flaky.setDown(false)
+	time.Sleep(time.Millisecond * 60)
+
+	// missing keys don't count as failures
+	_, err = bs.GetItem(ctx, "alice")
+	is.ErrorIs(err, ErrKeyNotFound)
+	is.Equal(BreakerClosed, bs.State())
+
+	is.Equal([]string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}, changes)
+}
+
+func TestBreakerStoreTimeout(t *testing.T) {
+	is := require.New(t)
+
+	bs := NewBreakerStore(slowStore{Store: NewMemoryStore(), delay: time.Second}, WithTimeout(time.Millisecond*20), WithThreshold(1))
+
+	start := time.Now()
+	_, err := bs.GetItem(context.Background(), "alice")
+	is.ErrorIs(err, context.DeadlineExceeded)
+	is.Less(int64(time.Since(start)), int64(time.Millisecond*500))
+	is.Equal(BreakerOpen, bs.State())
+
+	// requests cancelled by the caller leave the circuit alone
+	bs = NewBreakerStore(slowStore{Store: NewMemoryStore(), delay: time.Second}, WithThreshold(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = bs.GetItem(ctx, "alice")
+	is.ErrorIs(err, context.Canceled)
+	is.Equal(BreakerClosed, bs.State())
+}
+
+func TestBreakerStoreCancelled(t *testing.T) {
+	is := require.New(t)
+
+	bs := NewBreakerStore(slowStore{Store: NewMemoryStore(), delay: time.Second}, WithTimeout(time.Millisecond*10), WithThreshold(2), WithCooldown(time.Millisecond*20))
+
+	short := func() context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		t.Cleanup(cancel)
+
+		return ctx
+	}
+
+	// callers giving up first don't clear the failures of a hung store
+	_, err := bs.GetItem(context.Background(), "alice")
+	is.ErrorIs(err, context.DeadlineExceeded)
+
+	_, err = bs.GetItem(short(), "alice")
+	is.ErrorIs(err, context.DeadlineExceeded)
+	is.Equal(BreakerClosed, bs.State())
+
+	_, err = bs.GetItem(context.Background(), "alice")
+	is.ErrorIs(err, context.DeadlineExceeded)
+	is.Equal(BreakerOpen, bs.State())
+
+	// a cancelled probe leaves the circuit half-open for the next one
+	time.Sleep(time.Millisecond * 30)
+
+	_, err = bs.GetItem(short(), "alice")
+	is.ErrorIs(err, context.DeadlineExceeded)
+	is.Equal(BreakerHalfOpen, bs.State())
+
+	_, err = bs.GetItem(context.Background(), "alice")
+	is.ErrorIs(err, context.DeadlineExceeded)
+	is.Equal(BreakerOpen, bs.State())
+}
+
+func TestBreakerStoreFailover(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+
+	bs := NewBreakerStore(NewRedisStore(WithAddr(mr.Addr())), WithThreshold(2), WithCooldown(time.Minute))
+	rl := New(bs, RateLimitConfig{
+		Duration: time.Second * 60,
+		Limit:    5,
+		Failover: Failover{Mode: FailOpen, Retry: time.Nanosecond},
+	})
+
+	ctx := context.Background()
+
+	res, err := rl.Consume(ctx, "alice")
+	is.NoError(err)
+	is.False(res.Degraded)
+	is.Equal(4, res.Remaining)
+
+	mr.Close()
+
+	for i := 0; i < 4; i++ {
+		res, err := rl.Consume(ctx, "alice")
+		is.NoError(err)
+		is.True(res.Degraded)
+	}
+
+	is.Equal(BreakerOpen, bs.State())
+}
+
+func TestBreakerStoreBulk(t *testing.T) {
+	is := require.New(t)
+
+	testScanStore(t, NewBreakerStore(NewMemoryStore()))
+	testBulkStore(t, NewBreakerStore(NewMemoryStore()))
+
+	// capabilities the wrapped store lacks
+	bs := NewBreakerStore(&flakyStore{Store: NewMemoryStore()})
+
+	_, _, err := bs.Scan(context.Background(), "", "", 0)
+	is.ErrorIs(err, ErrNotSupported)
+
+	_, err = bs.Count(context.Background(), "")
+	is.ErrorIs(err, ErrNotSupported)
+}
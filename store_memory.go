@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,7 +18,7 @@ const (
 	InitialArraySize = 1024
 )
 
-var ErrHashKeyNotFound = errors.New("key not found in hash table")
+var ErrHashKeyNotFound = fmt.Errorf("%w in hash table", ErrKeyNotFound)
 
 type HashTable struct {
 	entries []*HashTableEntry
@@ -54,7 +55,7 @@ func NewMemoryStore(options ...OptionMemory) *MemoryStore {
 	logs := NewHashTable()
 
 	ms := &MemoryStore{
-		namespace: "x-ratelimit",
+		namespace: DefaultNamespace,
 		ttl:       MemoryTTL,
 		logs:      logs,
 	}
@@ -72,102 +73,173 @@ func WithTTL(ttl time.Duration) OptionMemory {
 	}
 }
 
+// WithNamespaceMemory sets the prefix of the store's keys
+func WithNamespaceMemory(namespace string) OptionMemory {
+	return func(ms *MemoryStore) {
+		ms.namespace = namespace
+	}
+}
+
 func (ms *MemoryStore) GetItem(ctx context.Context, key string) (*RequestLog, error) {
-	key = fmt.Sprintf("%s:%s", ms.namespace, key)
-	entries := ms.logs.entries
-	capacity := cap(ms.logs.entries)
-	index := ms.hashKey(key, capacity)
-	var log *RequestLog
+	ms.Lock()
+	defer ms.Unlock()
 
-	for entries[index].key != nil {
-		if strings.EqualFold(string(entries[index].key), key) {
-			if err := json.Unmarshal(entries[index].value, &log); err != nil {
-				return nil, err
-			}
+	return ms.getItem(key)
+}
 
-			return log, nil
-		}
+// len reports the number of entries held
+func (ms *MemoryStore) len() int {
+	ms.Lock()
+	defer ms.Unlock()
 
-		index++ // start linear probbing
+	return ms.logs.length
+}
 
-		if index >= len(entries) {
-			index = 0
-		}
-	}
+func (ms *MemoryStore) SetItem(ctx context.Context, key string, payload *RequestLog) error {
+	ms.Lock()
+	defer ms.Unlock()
 
-	return nil, ErrHashKeyNotFound
+	return ms.setItem(key, payload)
 }
 
-func (ms *MemoryStore) SetItem(ctx context.Context, key string, payload *RequestLog) error {
+func (ms *MemoryStore) UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error {
 	ms.Lock()
 	defer ms.Unlock()
 
-	key = fmt.Sprintf("%s:%s", ms.namespace, key)
-	capacity := cap(ms.logs.entries)
-	index := ms.hashKey(key, capacity)
+	logs := make([]*RequestLog, len(keys))
 
-	b, err := json.Marshal(payload)
+	for i, key := range keys {
+		log, err := ms.getItem(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+
+		logs[i] = log
+	}
+
+	logs, err := fn(logs)
 	if err != nil {
 		return err
 	}
 
-	if ms.logs.length >= (capacity / 2) {
-		if err := ms.expand(ctx); err != nil {
+	for i, log := range logs {
+		if log == nil {
+			continue
+		}
+
+		if err := ms.setItem(keys[i], log); err != nil {
 			return err
 		}
+	}
 
-		capacity = cap(ms.logs.entries)
-		index = ms.hashKey(key, capacity)
+	return nil
+}
+
+func (ms *MemoryStore) DeleteItem(ctx context.Context, key string) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	key = fmt.Sprintf("%s:%s", ms.namespace, key)
+
+	if !ms.logs.delete(key, ms.hashKey) {
+		return ErrHashKeyNotFound
 	}
 
-	entry := new(HashTableEntry)
-	entry.key = []byte(key)
-	entry.value = b
+	return nil
+}
 
-	for ms.logs.entries[index].key != nil {
-		if strings.EqualFold(string(ms.logs.entries[index].key), key) {
-			// key already exists
-			ms.logs.entries[index] = entry
+// Scan pages through keys in order, the cursor is the last key returned
+func (ms *MemoryStore) Scan(ctx context.Context, prefix, cursor string, count int) ([]string, string, error) {
+	ms.Lock()
 
-			ms.logs.length++
-			return nil
+	var keys []string
+	ns := ms.namespace + ":"
+
+	for _, key := range ms.keys(prefix) {
+		if key = strings.TrimPrefix(key, ns); cursor == "" || key > cursor {
+			keys = append(keys, key)
 		}
+	}
 
-		index++ // start linear probbing
+	ms.Unlock()
 
-		if index >= len(ms.logs.entries) {
-			index = 0
-		}
+	if count <= 0 {
+		count = ScanCount
 	}
 
-	ms.logs.entries[index] = entry
-	ms.logs.length++
+	sort.Strings(keys)
 
-	return nil
+	if len(keys) <= count {
+		return keys, "", nil
+	}
+
+	return keys[:count], keys[count-1], nil
 }
 
-func (ms *MemoryStore) DeleteItem(ctx context.Context, key string) error {
-	key = fmt.Sprintf("%s:%s", ms.namespace, key)
-	capacity := cap(ms.logs.entries)
-	index := ms.hashKey(key, capacity)
-	entries := ms.logs.entries
+func (ms *MemoryStore) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
 
-	for entries[index].key != nil {
-		if strings.EqualFold(string(entries[index].key), key) {
-			entries[index].key = nil
-			entries[index].value = nil
+	keys := ms.keys(prefix)
+	for _, key := range keys {
+		ms.logs.delete(key, ms.hashKey)
+	}
 
-			return nil
-		}
+	return len(keys), nil
+}
 
-		index++ // start linear probbing
+func (ms *MemoryStore) Count(ctx context.Context, prefix string) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
 
-		if index >= len(entries) {
-			index = 0
+	return len(ms.keys(prefix)), nil
+}
+
+// keys returns the namespaced keys starting with prefix
+func (ms *MemoryStore) keys(prefix string) []string {
+	var keys []string
+	prefix = fmt.Sprintf("%s:%s", ms.namespace, prefix)
+
+	for _, entry := range ms.logs.entries {
+		if entry.key != nil && strings.HasPrefix(string(entry.key), prefix) {
+			keys = append(keys, string(entry.key))
 		}
 	}
 
-	return ErrHashKeyNotFound
+	return keys
+}
+
+func (ms *MemoryStore) getItem(key string) (*RequestLog, error) {
+	key = fmt.Sprintf("%s:%s", ms.namespace, key)
+	var log *RequestLog
+
+	value, ok := ms.logs.get(key, ms.hashKey)
+	if !ok {
+		return nil, ErrHashKeyNotFound
+	}
+
+	if err := json.Unmarshal(value, &log); err != nil {
+		return nil, err
+	}
+
+	return log, nil
+}
+
+func (ms *MemoryStore) setItem(key string, payload *RequestLog) error {
+	key = fmt.Sprintf("%s:%s", ms.namespace, key)
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if ms.logs.length >= (cap(ms.logs.entries) / 2) {
+		ms.logs.expand(ms.hashKey)
+	}
+
+	ms.logs.set(key, b, ms.hashKey)
+
+	return nil
 }
 
 func (ms *MemoryStore) hashKey(key string, capacity int) int {
@@ -182,27 +254,76 @@ func (ms *MemoryStore) hashKey(key string, capacity int) int {
 	return int(h % uint64(capacity))
 }
 
-func (ms *MemoryStore) expand(ctx context.Context) error {
-	entries := ms.logs.entries
-	capacity := cap(entries)
+// lookup returns the index of key, or of the empty slot ending its probe sequence
+func (h *HashTable) lookup(key string, hash func(string, int) int) (int, bool) {
+	index := hash(key, len(h.entries))
 
-	nEntries := make([]*HashTableEntry, capacity*2)
+	for h.entries[index].key != nil {
+		if strings.EqualFold(string(h.entries[index].key), key) {
+			return index, true
+		}
 
-	ms.logs.entries = nEntries
+		index++ // start linear probbing
 
-	for _, v := range entries {
-		if v.key != nil {
-			var log *RequestLog
+		if index >= len(h.entries) {
+			index = 0
+		}
+	}
 
-			if err := json.Unmarshal(v.value, &log); err != nil {
-				return err
-			}
+	return index, false
+}
 
-			if err := ms.SetItem(ctx, string(v.key), log); err != nil {
-				return err
-			}
-		}
+func (h *HashTable) get(key string, hash func(string, int) int) ([]byte, bool) {
+	index, ok := h.lookup(key, hash)
+	if !ok {
+		return nil, false
 	}
 
-	return nil
+	return h.entries[index].value, true
+}
+
+func (h *HashTable) set(key string, value []byte, hash func(string, int) int) {
+	index, ok := h.lookup(key, hash)
+	if !ok {
+		h.length++
+	}
+
+	h.entries[index] = &HashTableEntry{key: []byte(key), value: value}
+}
+
+func (h *HashTable) delete(key string, hash func(string, int) int) bool {
+	index, ok := h.lookup(key, hash)
+	if !ok {
+		return false
+	}
+
+	h.entries[index] = &HashTableEntry{}
+	h.length--
+
+	// re-insert the rest of the cluster so later entries stay reachable
+	for i := (index + 1) % len(h.entries); h.entries[i].key != nil; i = (i + 1) % len(h.entries) {
+		entry := h.entries[i]
+		h.entries[i] = &HashTableEntry{}
+		h.length--
+		h.set(string(entry.key), entry.value, hash)
+	}
+
+	return true
+}
+
+func (h *HashTable) expand(hash func(string, int) int) {
+	entries := h.entries
+
+	h.entries = make([]*HashTableEntry, len(entries)*2)
+	h.length = 0
+
+	for i := range h.entries {
+		h.entries[i] = &HashTableEntry{}
+	}
+
+	for _, v := range entries {
+		if v.key != nil {
+			h.set(string(v.key), v.value, hash)
+		}
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -49,3 +50,105 @@ func TestGetSet(t *testing.T) {
 		t.Errorf("expected 'logs.length' to be 10, instead got: %d", ms.logs.length)
 	}
 }
+
+func TestExpandDelete(t *testing.T) {
+	is := require.New(t)
+
+	ms := NewMemoryStore()
+	ctx := context.Background()
+
+	payload := &RequestLog{
+		Timestamp: time.Now(),
+		Counter:   1,
+	}
+
+	// enough keys to force the table to grow
+	for i := 0; i < InitialArraySize; i++ {
+		err := ms.SetItem(ctx, strconv.Itoa(i), payload)
+		is.NoError(err)
+	}
+
+	is.Equal(InitialArraySize, ms.logs.length)
+	is.Equal(InitialArraySize*2, len(ms.logs.entries))
+
+	for i := 0; i < InitialArraySize; i += 2 {
+		err := ms.DeleteItem(ctx, strconv.Itoa(i))
+		is.NoError(err)
+	}
+
+	is.Equal(InitialArraySize/2, ms.logs.length)
+
+	for i := 0; i < InitialArraySize; i++ {
+		_, err := ms.GetItem(ctx, strconv.Itoa(i))
+		if i%2 == 0 {
+			is.ErrorIs(err, ErrKeyNotFound)
+		} else {
+			is.NoError(err)
+		}
+	}
+}
+
+func TestMemoryStoreScan(t *testing.T) {
+	testScanStore(t, NewMemoryStore())
+	testBulkStore(t, NewMemoryStore())
+}
+
+func TestHashTableDelete(t *testing.T) {
+	is := require.New(t)
+
+	h := NewHashTable()
+
+	// every key collides so they share one probe cluster
+	hash := func(string, int) int { return len(h.entries) - 2 }
+
+	for i := 0; i < 4; i++ {
+		h.set(strconv.Itoa(i), []byte{byte(i)}, hash)
+	}
+
+	is.Equal(4, h.length)
+	is.True(h.delete("1", hash))
+	is.False(h.delete("1", hash))
+	is.Equal(3, h.length)
+
+	for _, key := range []string{"0", "2", "3"} {
+		_, ok := h.get(key, hash)
+		is.True(ok, key)
+	}
+
+	_, ok := h.get("1", hash)
+	is.False(ok)
+}
+
+func TestExpandConcurrent(t *testing.T) {
+	is := require.New(t)
+
+	ms := NewMemoryStore()
+	ctx := context.Background()
+
+	payload := &RequestLog{
+		Timestamp: time.Now(),
+		Counter:   1,
+	}
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < InitialArraySize; i++ {
+				key := fmt.Sprintf("%d-%d", g, i)
+				is.NoError(ms.SetItem(ctx, key, payload))
+
+				_, err := ms.GetItem(ctx, key)
+				is.NoError(err)
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	is.Equal(4*InitialArraySize, ms.len())
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	redis "github.com/go-redis/redis/v8"
@@ -14,32 +16,81 @@ const RedisAddr = "localhost:6379"
 // Redis store
 type RedisStore struct {
 	client    *redis.Client
+	ownClient bool // the client was created by the store rather than passed with WithClient
 	namespace string
 	ttl       time.Duration
+	addr      string
 }
 
-func NewRedisStore() *RedisStore {
-	redis := redis.NewClient(&redis.Options{
-		Addr:        RedisAddr,
-		Password:    "",
-		DB:          0,
-		MaxRetries:  10,
-		DialTimeout: 15 * time.Second,
-	})
+type OptionRedis func(*RedisStore)
 
-	return &RedisStore{
-		client:    redis,
-		namespace: "x-ratelimit",
+func NewRedisStore(options ...OptionRedis) *RedisStore {
+	rs := &RedisStore{
+		namespace: DefaultNamespace,
 		ttl:       0,
+		addr:      RedisAddr,
+	}
+
+	for _, opt := range options {
+		opt(rs)
+	}
+
+	if rs.client == nil {
+		rs.client = redis.NewClient(&redis.Options{
+			Addr:        rs.addr,
+			Password:    "",
+			DB:          0,
+			MaxRetries:  10,
+			DialTimeout: 15 * time.Second,
+		})
+		rs.ownClient = true
+	}
+
+	return rs
+}
+
+func WithAddr(addr string) OptionRedis {
+	return func(rs *RedisStore) {
+		rs.addr = addr
+	}
+}
+
+// WithNamespaceRedis sets the prefix of the store's keys, so several stores
+// can share a redis database
+func WithNamespaceRedis(namespace string) OptionRedis {
+	return func(rs *RedisStore) {
+		rs.namespace = namespace
+	}
+}
+
+// WithClient uses client instead of connecting to the store's address, so
+// several stores can share its connections
+func WithClient(client *redis.Client) OptionRedis {
+	return func(rs *RedisStore) {
+		rs.client = client
 	}
 }
 
+// Close closes the store's connections, unless its client was passed with
+// WithClient
+func (s *RedisStore) Close() error {
+	if !s.ownClient {
+		return nil
+	}
+
+	return s.client.Close()
+}
+
 func (s *RedisStore) GetItem(ctx context.Context, key string) (*RequestLog, error) {
 	var log *RequestLog
 	key = fmt.Sprintf("%s:%s", s.namespace, key)
 
 	val, err := s.client.Get(ctx, key).Result()
 	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrKeyNotFound
+		}
+
 		return nil, err
 	}
 
@@ -65,12 +116,325 @@ func (s *RedisStore) SetItem(ctx context.Context, key string, payload *RequestLo
 	return nil
 }
 
+// UpdateItems runs fn inside a WATCH/MULTI transaction, retrying when another
+// client modifies one of the keys before the update is committed
+func (s *RedisStore) UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error {
+	nkeys := make([]string, len(keys))
+	for i, key := range keys {
+		nkeys[i] = fmt.Sprintf("%s:%s", s.namespace, key)
+	}
+
+	txf := func(tx *redis.Tx) error {
+		vals, err := tx.MGet(ctx, nkeys...).Result()
+		if err != nil {
+			return err
+		}
+
+		logs := make([]*RequestLog, len(keys))
+
+		for i, val := range vals {
+			str, ok := val.(string)
+			if !ok {
+				continue
+			}
+
+			if err := json.Unmarshal([]byte(str), &logs[i]); err != nil {
+				return err
+			}
+		}
+
+		logs, err = fn(logs)
+		if err != nil || logs == nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, log := range logs {
+				if log == nil {
+					continue
+				}
+
+				b, err := json.Marshal(log)
+				if err != nil {
+					return err
+				}
+
+				pipe.Set(ctx, nkeys[i], b, s.ttl)
+			}
+
+			return nil
+		})
+
+		return err
+	}
+
+	for i := 0; i < TxRetries; i++ {
+		err := s.client.Watch(ctx, txf, nkeys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return ErrTxConflict
+}
+
+// incrementScript checks and increments the windows of KEYS in one step.
+// ARGV holds the cost and ttl in milliseconds, then for every key the
+// seconds and nanoseconds of Since and Until (empty when unbounded), the
+// timestamp of a new window and the limit. It returns 1 when the windows were
+// incremented, 0 otherwise, followed by the windows as JSON.
+var incrementScript = redis.NewScript(`
+local function unix(ts)
+	local y, mo, d, h, mi, s, frac, tz = string.match(ts, '^(-?%d+)-(%d+)-(%d+)T(%d+):(%d+):(%d+)(%.?%d*)(.*)$')
+	if not y then
+		return nil
+	end
+
+	y, mo, d = tonumber(y), tonumber(mo), tonumber(d)
+	if mo <= 2 then
+		y = y - 1
+	end
+
+	local era = math.floor(y / 400)
+	local yoe = y - era * 400
+	local doy = math.floor((153 * ((mo + 9) % 12) + 2) / 5) + d - 1
+	local days = era * 146097 + yoe * 365 + math.floor(yoe / 4) - math.floor(yoe / 100) + doy - 719468
+
+	local offset = 0
+	if tz ~= 'Z' then
+		local sign, oh, om = string.match(tz, '^([+-])(%d+):(%d+)$')
+		if not sign then
+			return nil
+		end
+
+		offset = tonumber(oh) * 3600 + tonumber(om) * 60
+		if sign == '-' then
+			offset = -offset
+		end
+	end
+
+	local nsec = 0
+	if frac ~= '' then
+		nsec = tonumber(string.sub(string.sub(frac, 2) .. '000000000', 1, 9))
+	end
+
+	return days * 86400 + tonumber(h) * 3600 + tonumber(mi) * 60 + tonumber(s) - offset, nsec
+end
+
+local function before(sec, nsec, bsec, bnsec)
+	bsec, bnsec = tonumber(bsec), tonumber(bnsec)
+	return sec < bsec or (sec == bsec and nsec < bnsec)
+end
+
+local n, ttl = tonumber(ARGV[1]), tonumber(ARGV[2])
+local windows, ok = {}, true
+
+for i, key in ipairs(KEYS) do
+	local a = 2 + (i - 1) * 6
+	local window = {ts = ARGV[a + 5], count = 0}
+
+	local raw = redis.call('GET', key)
+	if raw then
+		local log = cjson.decode(raw)
+		local sec, nsec = unix(log.Timestamp)
+		if not sec then
+			return redis.error_reply('invalid timestamp ' .. tostring(log.Timestamp))
+		end
+
+		if not before(sec, nsec, ARGV[a + 1], ARGV[a + 2]) and (ARGV[a + 3] == '' or before(sec, nsec, ARGV[a + 3], ARGV[a + 4])) then
+			window = {ts = log.Timestamp, count = log.Counter}
+		end
+	end
+
+	if window.count + n > tonumber(ARGV[a + 6]) then
+		ok = false
+	end
+
+	windows[i] = window
+end
+
+local reply = {ok and 1 or 0}
+
+for i, key in ipairs(KEYS) do
+	local window = windows[i]
+	if ok then
+		window.count = window.count + n
+	end
+
+	local log = '{"Timestamp":"' .. window.ts .. '","Counter":' .. string.format('%d', window.count) .. '}'
+	if ok then
+		if ttl > 0 then
+			redis.call('SET', key, log, 'PX', ttl)
+		else
+			redis.call('SET', key, log)
+		end
+	end
+
+	reply[i + 1] = log
+end
+
+return reply
+`)
+
+// IncrementItems runs the check and increment as a script, so concurrent
+// updates of the same keys never conflict
+func (s *RedisStore) IncrementItems(ctx context.Context, incs []Increment, n int) ([]*RequestLog, bool, error) {
+	nkeys := make([]string, len(incs))
+	args := make([]interface{}, 0, 2+len(incs)*6)
+	args = append(args, n, s.ttl.Milliseconds())
+
+	for i, inc := range incs {
+		nkeys[i] = fmt.Sprintf("%s:%s", s.namespace, inc.Key)
+
+		var until, untilNsec interface{} = "", ""
+		if !inc.Until.IsZero() {
+			until, untilNsec = inc.Until.Unix(), inc.Until.Nanosecond()
+		}
+
+		args = append(args, inc.Since.Unix(), inc.Since.Nanosecond(), until, untilNsec, inc.Start.Format(time.RFC3339Nano), inc.Limit)
+	}
+
+	reply, err := incrementScript.Run(ctx, s.client, nkeys, args...).Slice()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(reply) != len(incs)+1 {
+		return nil, false, fmt.Errorf("unexpected increment reply of %d values", len(reply))
+	}
+
+	logs := make([]*RequestLog, len(incs))
+
+	for i, val := range reply[1:] {
+		str, _ := val.(string)
+		if err := json.Unmarshal([]byte(str), &logs[i]); err != nil {
+			return nil, false, err
+		}
+	}
+
+	ok, _ := reply[0].(int64)
+
+	return logs, ok == 1, nil
+}
+
 func (s *RedisStore) DeleteItem(ctx context.Context, key string) error {
 	key = fmt.Sprintf("%s:%s", s.namespace, key)
 
-	if err := s.client.Del(ctx, key).Err(); err != nil {
+	n, err := s.client.Del(ctx, key).Result()
+	if err != nil {
 		return err
 	}
 
+	if n == 0 {
+		return ErrKeyNotFound
+	}
+
 	return nil
 }
+
+// Scan pages through keys with SCAN, the cursor is redis' own
+func (s *RedisStore) Scan(ctx context.Context, prefix, cursor string, count int) ([]string, string, error) {
+	var rc uint64
+
+	if cursor != "" {
+		var err error
+		if rc, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", fmt.Errorf("invalid scan cursor %q", cursor)
+		}
+	}
+
+	if count <= 0 {
+		count = ScanCount
+	}
+
+	ns := s.namespace + ":"
+
+	nkeys, next, err := s.client.Scan(ctx, rc, escapeGlob(ns+prefix)+"*", int64(count)).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := make([]string, len(nkeys))
+	for i, key := range nkeys {
+		keys[i] = strings.TrimPrefix(key, ns)
+	}
+
+	if next == 0 {
+		return keys, "", nil
+	}
+
+	return keys, strconv.FormatUint(next, 10), nil
+}
+
+// DeleteByPrefix scans for the keys and unlinks them a page at a time, keys
+// written meanwhile may be left
+func (s *RedisStore) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	var deleted int
+
+	err := s.scanAll(ctx, prefix, func(nkeys []string) error {
+		n, err := s.client.Unlink(ctx, nkeys...).Result()
+		deleted += int(n)
+
+		return err
+	})
+
+	return deleted, err
+}
+
+// Count scans every key starting with prefix, which takes time proportional
+// to the size of the whole database
+func (s *RedisStore) Count(ctx context.Context, prefix string) (int, error) {
+	// SCAN may return a key more than once
+	seen := make(map[string]struct{})
+
+	err := s.scanAll(ctx, prefix, func(nkeys []string) error {
+		for _, key := range nkeys {
+			seen[key] = struct{}{}
+		}
+
+		return nil
+	})
+
+	return len(seen), err
+}
+
+// scanAll calls fn with every page of namespaced keys starting with prefix
+func (s *RedisStore) scanAll(ctx context.Context, prefix string, fn func(nkeys []string) error) error {
+	match := escapeGlob(s.namespace+":"+prefix) + "*"
+	var cursor uint64
+
+	for {
+		nkeys, next, err := s.client.Scan(ctx, cursor, match, ScanCount).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(nkeys) > 0 {
+			if err := fn(nkeys); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+
+		cursor = next
+	}
+}
+
+// escapeGlob escapes the characters redis treats as patterns in MATCH
+func escapeGlob(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"testing"
 	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetItem(t *testing.T) {
@@ -48,3 +52,90 @@ func TestSetItem(t *testing.T) {
 		}
 	})
 }
+
+func TestRedisStoreScan(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	testScanStore(t, NewRedisStore(WithAddr(mr.Addr())))
+
+	mr.FlushAll()
+	testBulkStore(t, NewRedisStore(WithAddr(mr.Addr())))
+}
+
+func TestRedisStoreNamespace(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	// stores sharing one client keep their keys apart
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	edge := NewRedisStore(WithClient(client), WithNamespaceRedis("edge"))
+	internal := NewRedisStore(WithClient(client), WithNamespaceRedis("internal"))
+	ctx := context.Background()
+
+	is.NoError(edge.SetItem(ctx, "alice", &RequestLog{Timestamp: time.Now(), Counter: 3}))
+
+	_, err = internal.GetItem(ctx, "alice")
+	is.ErrorIs(err, ErrKeyNotFound)
+
+	is.True(mr.Exists("edge:alice"))
+
+	count, err := internal.Count(ctx, "")
+	is.NoError(err)
+	is.Zero(count)
+}
+
+func TestRedisStoreIncrement(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	store := NewRedisStore(WithAddr(mr.Addr()))
+	ctx := context.Background()
+
+	now := time.Now()
+	started := now.Add(-time.Second * 30).In(time.FixedZone("", 2*60*60))
+	is.NoError(store.SetItem(ctx, "alice", &RequestLog{Timestamp: started, Counter: 3}))
+
+	incs := []Increment{
+		{Key: "alice", Since: now.Add(-time.Minute + 1), Start: now, Limit: 5},
+		{Key: "bob", Since: now.Add(-time.Minute + 1), Start: now, Limit: 5},
+	}
+
+	logs, ok, err := store.IncrementItems(ctx, incs, 2)
+	is.NoError(err)
+	is.True(ok)
+	is.Equal(5, logs[0].Counter)
+	is.True(started.Equal(logs[0].Timestamp))
+	is.Equal(2, logs[1].Counter)
+	is.True(now.Equal(logs[1].Timestamp))
+
+	// nothing is written when one window would exceed its limit
+	logs, ok, err = store.IncrementItems(ctx, incs, 1)
+	is.NoError(err)
+	is.False(ok)
+	is.Equal(5, logs[0].Counter)
+
+	bob, err := store.GetItem(ctx, "bob")
+	is.NoError(err)
+	is.Equal(2, bob.Counter)
+
+	// windows outside the bounds start again
+	incs[0].Since = started.Add(1)
+	incs[1].Until = now
+
+	logs, ok, err = store.IncrementItems(ctx, incs, 1)
+	is.NoError(err)
+	is.True(ok)
+	is.Equal(1, logs[0].Counter)
+	is.True(now.Equal(logs[0].Timestamp))
+	is.Equal(1, logs[1].Counter)
+}
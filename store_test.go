@@ -0,0 +1,91 @@
+package xratelimit
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scanAll pages through every key starting with prefix
+func scanAll(t *testing.T, ss ScanStore, prefix string, count int) []string {
+	var keys []string
+	var cursor string
+
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 1000, "scan doesn't end")
+
+		page, next, err := ss.Scan(context.Background(), prefix, cursor, count)
+		require.NoError(t, err)
+
+		keys = append(keys, page...)
+		if next == "" {
+			break
+		}
+
+		cursor = next
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// testScanStore checks the ScanStore contract against an empty store
+func testScanStore(t *testing.T, ss ScanStore) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	keys := []string{"user:alice", "user:bob", "user:carol", "user:dave", "user:*", "ip:10.0.0.1", "ip:10.0.0.2"}
+	for _, key := range keys {
+		is.NoError(ss.SetItem(ctx, key, &RequestLog{Timestamp: time.Now(), Counter: 1}))
+	}
+
+	is.Equal([]string{"user:*", "user:alice", "user:bob", "user:carol", "user:dave"}, scanAll(t, ss, "user:", 2))
+	is.Equal([]string{"ip:10.0.0.1", "ip:10.0.0.2"}, scanAll(t, ss, "ip:", 0))
+	is.Len(scanAll(t, ss, "", 3), len(keys))
+	is.Empty(scanAll(t, ss, "none:", 0))
+
+	// patterns in the prefix are matched literally
+	is.Equal([]string{"user:*"}, scanAll(t, ss, "user:*", 0))
+
+	is.NoError(ss.DeleteItem(ctx, "user:bob"))
+	is.ErrorIs(ss.DeleteItem(ctx, "user:bob"), ErrKeyNotFound)
+	is.Equal([]string{"user:*", "user:alice", "user:carol", "user:dave"}, scanAll(t, ss, "user:", 10))
+}
+
+// testBulkStore checks the BulkStore contract against an empty store
+func testBulkStore(t *testing.T, bs BulkStore) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"user:alice", "user:bob", "user[1]", "ip:10.0.0.1"} {
+		is.NoError(bs.SetItem(ctx, key, &RequestLog{Timestamp: time.Now(), Counter: 1}))
+	}
+
+	count, err := bs.Count(ctx, "user")
+	is.NoError(err)
+	is.Equal(3, count)
+
+	count, err = bs.Count(ctx, "user[")
+	is.NoError(err)
+	is.Equal(1, count)
+
+	deleted, err := bs.DeleteByPrefix(ctx, "user:")
+	is.NoError(err)
+	is.Equal(2, deleted)
+
+	_, err = bs.GetItem(ctx, "user:alice")
+	is.ErrorIs(err, ErrKeyNotFound)
+
+	is.Equal([]string{"ip:10.0.0.1", "user[1]"}, scanAll(t, bs, "", 0))
+
+	deleted, err = bs.DeleteByPrefix(ctx, "none:")
+	is.NoError(err)
+	is.Zero(deleted)
+
+	count, err = bs.Count(ctx, "")
+	is.NoError(err)
+	is.Equal(2, count)
+}
@@ -0,0 +1,496 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TieredSyncInterval = time.Millisecond * 100
+
+// TieredStore counts in memory in front of a shared remote store such as
+// RedisStore. Requests are counted locally and the deltas are flushed to the
+// remote store every sync interval, pulling back the global counts made by
+// other instances. Between syncs an instance only sees its own requests, so a
+// limit can be overshot by up to maxDelta per instance when maxDelta is set,
+// and by whatever each instance admits in one interval otherwise.
+//
+// Keys are read through from the remote store the first time they are used,
+// and dropped from memory when they saw no requests between two syncs.
+type TieredStore struct {
+	remote   Store
+	interval time.Duration
+	maxDelta int
+	onError  func(err error)
+
+	mu      sync.Mutex
+	entries map[string]*tieredEntry
+	syncMu  sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+type tieredEntry struct {
+	base    *RequestLog // remote log at the last sync, nil when there was none
+	view    *RequestLog // base plus local requests, what GetItem returns
+	delta   int         // local requests not yet flushed
+	touched bool        // used since the last sync
+}
+
+type OptionTiered func(*TieredStore)
+
+// NewTieredStore starts syncing with remote in the background until Close
+func NewTieredStore(remote Store, options ...OptionTiered) *TieredStore {
+	ts := &TieredStore{
+		remote:   remote,
+		interval: TieredSyncInterval,
+		entries:  make(map[string]*tieredEntry),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(ts)
+	}
+
+	go ts.run()
+
+	return ts
+}
+
+// WithSyncInterval sets how often deltas are flushed to the remote store
+func WithSyncInterval(interval time.Duration) OptionTiered {
+	return func(ts *TieredStore) {
+		ts.interval = interval
+	}
+}
+
+// WithMaxDelta syncs a key as soon as it has maxDelta unflushed requests,
+// bounding how far each instance can overshoot a limit
+func WithMaxDelta(maxDelta int) OptionTiered {
+	return func(ts *TieredStore) {
+		ts.maxDelta = maxDelta
+	}
+}
+
+// WithOnSyncError is called when flushing to the remote store fails. The
+// deltas are kept and flushed again on the next sync.
+func WithOnSyncError(fn func(err error)) OptionTiered {
+	return func(ts *TieredStore) {
+		ts.onError = fn
+	}
+}
+
+func (ts *TieredStore) run() {
+	defer close(ts.done)
+
+	ticker := time.NewTicker(ts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ts.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := ts.Sync(context.Background()); err != nil && ts.onError != nil {
+			ts.onError(err)
+		}
+	}
+}
+
+// Close stops the background sync and flushes the remaining deltas
+func (ts *TieredStore) Close() error {
+	ts.once.Do(func() {
+		close(ts.stop)
+	})
+
+	<-ts.done
+
+	return ts.Sync(context.Background())
+}
+
+func (ts *TieredStore) GetItem(ctx context.Context, key string) (*RequestLog, error) {
+	if err := ts.load(ctx, []string{key}); err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry := ts.entry(key)
+
+	if entry.view == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	view := *entry.view
+	return &view, nil
+}
+
+func (ts *TieredStore) SetItem(ctx context.Context, key string, payload *RequestLog) error {
+	return ts.UpdateItems(ctx, []string{key}, func(logs []*RequestLog) ([]*RequestLog, error) {
+		return []*RequestLog{payload}, nil
+	})
+}
+
+// DeleteItem drops key locally and in the remote store, a key that only
+// exists locally because it hasn't been synced yet still counts as deleted
+func (ts *TieredStore) DeleteItem(ctx context.Context, key string) error {
+	ts.mu.Lock()
+	_, local := ts.entries[key]
+	delete(ts.entries, key)
+	ts.mu.Unlock()
+
+	err := ts.remote.DeleteItem(ctx, key)
+	if local && errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// UpdateItems is atomic within this instance, other instances see the result
+// after the next sync
+func (ts *TieredStore) UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error {
+	if err := ts.load(ctx, keys); err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+
+	logs := make([]*RequestLog, len(keys))
+	for i, key := range keys {
+		if view := ts.entry(key).view; view != nil {
+			v := *view
+			logs[i] = &v
+		}
+	}
+
+	logs, err := fn(logs)
+	if err != nil {
+		ts.mu.Unlock()
+		return err
+	}
+
+	var full []string
+
+	for i, rlog := range logs {
+		if rlog == nil {
+			continue
+		}
+
+		entry := ts.entry(keys[i])
+		entry.set(rlog)
+
+		if ts.maxDelta > 0 && abs(entry.delta) >= ts.maxDelta {
+			full = append(full, keys[i])
+		}
+	}
+
+	ts.mu.Unlock()
+
+	if len(full) > 0 {
+		// the requests are counted locally either way, so a failed flush is
+		// only reported
+		if err := ts.sync(ctx, full); err != nil && ts.onError != nil {
+			ts.onError(err)
+		}
+	}
+
+	return nil
+}
+
+// Scan lists the keys of the remote store, which lacks keys not synced yet.
+// It returns ErrNotSupported when the remote store isn't a ScanStore.
+func (ts *TieredStore) Scan(ctx context.Context, prefix, cursor string, count int) ([]string, string, error) {
+	ss, ok := ts.remote.(ScanStore)
+	if !ok {
+		return nil, "", ErrNotSupported
+	}
+
+	return ss.Scan(ctx, prefix, cursor, count)
+}
+
+// DeleteByPrefix drops the keys locally and in the remote store. It returns
+// ErrNotSupported when the remote store isn't a BulkStore.
+func (ts *TieredStore) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	bulk, ok := ts.remote.(BulkStore)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	ts.mu.Lock()
+	for key := range ts.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(ts.entries, key)
+		}
+	}
+	ts.mu.Unlock()
+
+	return bulk.DeleteByPrefix(ctx, prefix)
+}
+
+// Count counts the keys of the remote store, which lacks keys not synced yet.
+// It returns ErrNotSupported when the remote store isn't a BulkStore.
+func (ts *TieredStore) Count(ctx context.Context, prefix string) (int, error) {
+	bulk, ok := ts.remote.(BulkStore)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	return bulk.Count(ctx, prefix)
+}
+
+// load reads keys that aren't held locally from the remote store
+func (ts *TieredStore) load(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		ts.mu.Lock()
+		entry, ok := ts.entries[key]
+		if ok {
+			entry.touched = true
+		}
+		ts.mu.Unlock()
+
+		if ok {
+			continue
+		}
+
+		rlog, err := ts.remote.GetItem(ctx, key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+
+		ts.mu.Lock()
+		if _, ok := ts.entries[key]; !ok {
+			ts.entries[key] = &tieredEntry{base: copyLog(rlog), view: copyLog(rlog), touched: true}
+		}
+		ts.mu.Unlock()
+	}
+
+	return nil
+}
+
+// entry returns key's entry, marked as used. A key deleted or dropped by a
+// sync since load starts over empty, and its requests are added to the
+// remote window on the next sync. ts.mu must be held.
+func (ts *TieredStore) entry(key string) *tieredEntry {
+	entry, ok := ts.entries[key]
+	if !ok {
+		entry = &tieredEntry{}
+		ts.entries[key] = entry
+	}
+
+	entry.touched = true
+	return entry
+}
+
+// Sync flushes every key's delta to the remote store and pulls back the
+// global counts, dropping keys that weren't used since the previous sync
+func (ts *TieredStore) Sync(ctx context.Context) error {
+	ts.mu.Lock()
+
+	keys := make([]string, 0, len(ts.entries))
+	for key, entry := range ts.entries {
+		if !entry.touched && entry.delta == 0 {
+			delete(ts.entries, key)
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	ts.mu.Unlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return ts.sync(ctx, keys)
+}
+
+func (ts *TieredStore) sync(ctx context.Context, keys []string) error {
+	ts.syncMu.Lock()
+	defer ts.syncMu.Unlock()
+
+	ts.mu.Lock()
+
+	snapshots := make([]tieredEntry, 0, len(keys))
+	synced := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		if entry, ok := ts.entries[key]; ok {
+			snapshots = append(snapshots, *entry)
+			synced = append(synced, key)
+			entry.touched = false
+		}
+	}
+
+	ts.mu.Unlock()
+
+	// keys are flushed one at a time, so a write by another instance to one
+	// key doesn't hold back the deltas of the others
+	var first error
+
+	for i, key := range synced {
+		merged, err := ts.flush(ctx, key, snapshots[i])
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+
+			continue
+		}
+
+		ts.apply(key, snapshots[i], merged)
+	}
+
+	return first
+}
+
+// flush adds a snapshot's delta to key's remote log, returning the merged log
+func (ts *TieredStore) flush(ctx context.Context, key string, snapshot tieredEntry) (*RequestLog, error) {
+	var merged *RequestLog
+
+	err := updateItems(ctx, ts.remote, []string{key}, func(remote []*RequestLog) ([]*RequestLog, error) {
+		merged = mergeLog(remote[0], snapshot)
+
+		// only write what changed
+		if remote[0] == nil || snapshot.delta != 0 || !merged.Timestamp.Equal(remote[0].Timestamp) {
+			return []*RequestLog{merged}, nil
+		}
+
+		return nil, nil
+	})
+
+	return merged, err
+}
+
+// apply makes the merged log the base of key's entry, keeping requests counted
+// while syncing
+func (ts *TieredStore) apply(key string, snapshot tieredEntry, merged *RequestLog) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry, ok := ts.entries[key]
+	if !ok || merged == nil {
+		return
+	}
+
+	entry.base = copyLog(merged)
+
+	// a window started locally while syncing is kept, with its delta
+	rolled := entry.view != nil && (snapshot.view == nil || !entry.view.Timestamp.Equal(snapshot.view.Timestamp))
+	if rolled {
+		return
+	}
+
+	// requests counted while syncing stay as the new delta
+	entry.delta -= snapshot.delta
+	entry.view = &RequestLog{Timestamp: merged.Timestamp, Counter: merged.Counter + entry.delta}
+}
+
+// set records rlog as the key's new state, counting the difference as local
+// requests
+func (e *tieredEntry) set(rlog *RequestLog) {
+	if e.view != nil && rlog.Timestamp.Equal(e.view.Timestamp) {
+		e.delta += rlog.Counter - e.view.Counter
+	} else {
+		// a new window, requests left from the old one no longer matter
+		e.delta = rlog.Counter
+	}
+
+	e.view = copyLog(rlog)
+	e.touched = true
+}
+
+// mergeLog adds a snapshot's delta to the remote log
+func mergeLog(remote *RequestLog, snapshot tieredEntry) *RequestLog {
+	if snapshot.view == nil {
+		return copyLog(remote)
+	}
+
+	var baseTS time.Time
+	if snapshot.base != nil {
+		baseTS = snapshot.base.Timestamp
+	}
+
+	// the delta belongs to a window started locally since the last sync
+	rolled := !snapshot.view.Timestamp.Equal(baseTS)
+
+	switch {
+	case remote == nil:
+		return copyLog(snapshot.view)
+	case remote.Timestamp.After(baseTS):
+		// another instance started a window since the last sync; count new
+		// requests in it, requests from the old window no longer matter
+		merged := copyLog(remote)
+		if rolled {
+			merged.Counter += snapshot.delta
+		}
+
+		return merged
+	case rolled:
+		return &RequestLog{Timestamp: snapshot.view.Timestamp, Counter: snapshot.delta}
+	}
+
+	merged := copyLog(remote)
+	merged.Counter += snapshot.delta
+
+	return merged
+}
+
+// updateItems updates keys atomically when store is a TxStore
+func updateItems(ctx context.Context, store Store, keys []string, fn UpdateFunc) error {
+	if tx, ok := store.(TxStore); ok {
+		return tx.UpdateItems(ctx, keys, fn)
+	}
+
+	logs := make([]*RequestLog, len(keys))
+	for i, key := range keys {
+		rlog, err := store.GetItem(ctx, key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+
+		logs[i] = rlog
+	}
+
+	logs, err := fn(logs)
+	if err != nil {
+		return err
+	}
+
+	for i, rlog := range logs {
+		if rlog == nil {
+			continue
+		}
+
+		if err := store.SetItem(ctx, keys[i], rlog); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyLog(rlog *RequestLog) *RequestLog {
+	if rlog == nil {
+		return nil
+	}
+
+	c := *rlog
+	return &c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
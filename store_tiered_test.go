@@ -0,0 +1,341 @@
+package xratelimit
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredStore(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	redis := NewRedisStore(WithAddr(mr.Addr()))
+	config := RateLimitConfig{Duration: time.Minute, Limit: 10}
+	ctx := context.Background()
+
+	// a long interval so that only explicit syncs reach redis
+	a := NewTieredStore(redis, WithSyncInterval(time.Hour))
+	b := NewTieredStore(redis, WithSyncInterval(time.Hour))
+	rla, rlb := New(a, config), New(b, config)
+
+	for i := 0; i < 3; i++ {
+		_, err := rla.Consume(ctx, "alice")
+		is.NoError(err)
+	}
+
+	_, err = redis.GetItem(ctx, "alice")
+	is.ErrorIs(err, ErrKeyNotFound)
+
+	is.NoError(a.Sync(ctx))
+
+	// b reads alice through from redis
+	res, err := rlb.Consume(ctx, "alice")
+	is.NoError(err)
+	is.Equal(6, res.Remaining)
+
+	rla.Consume(ctx, "alice")
+	rlb.Consume(ctx, "alice")
+
+	is.NoError(a.Sync(ctx))
+	is.NoError(b.Sync(ctx))
+	is.NoError(a.Sync(ctx))
+
+	rlog, err := redis.GetItem(ctx, "alice")
+	is.NoError(err)
+	is.Equal(6, rlog.Counter)
+
+	res, err = rla.Peek(ctx, "alice")
+	is.NoError(err)
+	is.Equal(4, res.Remaining)
+
+	// Close flushes what is left
+	rlb.Consume(ctx, "alice")
+	is.NoError(b.Close())
+	is.NoError(a.Close())
+
+	rlog, err = redis.GetItem(ctx, "alice")
+	is.NoError(err)
+	is.Equal(7, rlog.Counter)
+}
+
+func TestTieredStoreDelete(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	redis := NewRedisStore(WithAddr(mr.Addr()))
+	ts := NewTieredStore(redis, WithSyncInterval(time.Hour))
+	defer ts.Close()
+
+	ctx := context.Background()
+	rl := New(ts, RateLimitConfig{Duration: time.Minute, Limit: 10})
+
+	// alice hasn't reached redis yet
+	_, err = rl.Consume(ctx, "alice")
+	is.NoError(err)
+	is.NoError(ts.DeleteItem(ctx, "alice"))
+
+	is.ErrorIs(ts.DeleteItem(ctx, "alice"), ErrKeyNotFound)
+	is.ErrorIs(ts.DeleteItem(ctx, "bob"), ErrKeyNotFound)
+}
+
+func TestTieredStoreConcurrentDelete(t *testing.T) {
+	is := require.New(t)
+
+	ts := NewTieredStore(NewMemoryStore(), WithSyncInterval(time.Millisecond))
+	defer ts.Close()
+
+	ctx := context.Background()
+	rl := New(ts, RateLimitConfig{Duration: time.Minute, Limit: 1000000})
+
+	// admin deletes race with requests and syncs that prune keys
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 8; g++ {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 2000; i++ {
+				_, err := ts.GetItem(ctx, "alice")
+				if err != nil {
+					is.ErrorIs(err, ErrKeyNotFound)
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 2000; i++ {
+				_, err := rl.Consume(ctx, "alice")
+				is.NoError(err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 2000; i++ {
+				if err := ts.DeleteItem(ctx, "alice"); err != nil {
+					is.ErrorIs(err, ErrKeyNotFound)
+				}
+
+				_, err := ts.DeleteByPrefix(ctx, "al")
+				is.NoError(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestTieredStoreOvershoot(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	redis := NewRedisStore(WithAddr(mr.Addr()))
+	ctx := context.Background()
+
+	// allowed counts the requests two instances admit when they alternate
+	// until both reject, with nothing synced in the background
+	allowed := func(key string, options ...OptionTiered) int {
+		options = append(options, WithSyncInterval(time.Hour))
+		a, b := NewTieredStore(redis, options...), NewTieredStore(redis, options...)
+		defer a.Close()
+		defer b.Close()
+
+		config := RateLimitConfig{Duration: time.Minute, Limit: 10}
+		limiters := []*RateLimit{New(a, config), New(b, config)}
+
+		var total int
+		for rejected := 0; rejected < len(limiters); {
+			rejected = 0
+
+			for _, rl := range limiters {
+				if _, err := rl.Consume(ctx, key); err != nil {
+					is.ErrorIs(err, ErrRateLimitExceeded)
+					rejected++
+					continue
+				}
+
+				total++
+			}
+		}
+
+		return total
+	}
+
+	// without syncing each instance admits the full limit
+	is.Equal(20, allowed("unbounded"))
+
+	// with maxDelta each instance overshoots by at most maxDelta
+	total := allowed("bounded", WithMaxDelta(2))
+	is.GreaterOrEqual(total, 10)
+	is.LessOrEqual(total, 10+2*2)
+}
+
+// hotStore is a TxStore whose updates of hot always conflict
+type hotStore struct {
+	*MemoryStore
+	hot string
+}
+
+func (hs *hotStore) UpdateItems(ctx context.Context, keys []string, fn UpdateFunc) error {
+	for _, key := range keys {
+		if key == hs.hot {
+			return ErrTxConflict
+		}
+	}
+
+	return hs.MemoryStore.UpdateItems(ctx, keys, fn)
+}
+
+func TestTieredStoreSyncConflict(t *testing.T) {
+	is := require.New(t)
+
+	remote := &hotStore{MemoryStore: NewMemoryStore(), hot: "hot"}
+	ts := NewTieredStore(remote, WithSyncInterval(time.Hour))
+	rl := New(ts, RateLimitConfig{Duration: time.Minute, Limit: 10})
+	ctx := context.Background()
+
+	for _, key := range []string{"hot", "cold"} {
+		_, err := rl.Consume(ctx, key)
+		is.NoError(err)
+	}
+
+	// a conflicting key doesn't hold back the others
+	is.ErrorIs(ts.Sync(ctx), ErrTxConflict)
+
+	cold, err := remote.GetItem(ctx, "cold")
+	is.NoError(err)
+	is.Equal(1, cold.Counter)
+
+	// and its delta is flushed once it stops conflicting
+	remote.hot = ""
+	is.NoError(ts.Close())
+
+	hot, err := remote.GetItem(ctx, "hot")
+	is.NoError(err)
+	is.Equal(1, hot.Counter)
+}
+
+func TestTieredStoreConcurrentSync(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	redis := NewRedisStore(WithAddr(mr.Addr()))
+	config := RateLimitConfig{Duration: time.Minute, Limit: 100000}
+
+	// two instances flushing the same hot keys as often as they can
+	stores := []*TieredStore{
+		NewTieredStore(redis, WithSyncInterval(time.Millisecond), WithMaxDelta(1)),
+		NewTieredStore(redis, WithSyncInterval(time.Millisecond), WithMaxDelta(1)),
+	}
+
+	keys := []string{"hot", "warm"}
+	var w sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		w.Add(1)
+
+		go func(rl *RateLimit) {
+			defer w.Done()
+
+			for j := 0; j < 100; j++ {
+				if _, err := rl.Consume(context.Background(), keys[j%len(keys)]); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+		}(New(stores[i%2], config))
+	}
+
+	w.Wait()
+
+	for _, ts := range stores {
+		is.NoError(ts.Close())
+	}
+
+	// every delta reaches the remote store
+	for _, key := range keys {
+		rlog, err := redis.GetItem(context.Background(), key)
+		is.NoError(err)
+		is.Equal(400, rlog.Counter)
+	}
+}
+
+func TestTieredStoreBackgroundSync(t *testing.T) {
+	is := require.New(t)
+
+	mr, err := miniredis.Run()
+	is.NoError(err)
+	defer mr.Close()
+
+	redis := NewRedisStore(WithAddr(mr.Addr()))
+	ts := NewTieredStore(redis, WithSyncInterval(time.Millisecond*10))
+	defer ts.Close()
+
+	_, err = New(ts, RateLimitConfig{Duration: time.Minute, Limit: 10}).Consume(context.Background(), "alice")
+	is.NoError(err)
+
+	is.Eventually(func() bool {
+		rlog, err := redis.GetItem(context.Background(), "alice")
+		return err == nil && rlog.Counter == 1
+	}, time.Second, time.Millisecond*10)
+
+	// idle keys are dropped from memory
+	is.Eventually(func() bool {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+
+		return len(ts.entries) == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestMergeLog(t *testing.T) {
+	is := require.New(t)
+
+	t0 := time.Date(2021, time.November, 1, 12, 0, 0, 0, time.UTC)
+	t1, t2 := t0.Add(time.Minute), t0.Add(time.Minute*2)
+	log := func(ts time.Time, counter int) *RequestLog {
+		return &RequestLog{Timestamp: ts, Counter: counter}
+	}
+
+	cases := []struct {
+		name     string
+		remote   *RequestLog
+		snapshot tieredEntry
+		want     *RequestLog
+	}{
+		{"first write", nil, tieredEntry{view: log(t0, 2), delta: 2}, log(t0, 2)},
+		{"same window", log(t0, 5), tieredEntry{base: log(t0, 3), view: log(t0, 4), delta: 1}, log(t0, 6)},
+		{"rolled locally", log(t0, 5), tieredEntry{base: log(t0, 3), view: log(t1, 2), delta: 2}, log(t1, 2)},
+		{"rolled remotely", log(t1, 4), tieredEntry{base: log(t0, 3), view: log(t0, 5), delta: 2}, log(t1, 4)},
+		{"rolled on both", log(t1, 4), tieredEntry{base: log(t0, 3), view: log(t2, 2), delta: 2}, log(t1, 6)},
+		{"created elsewhere", log(t1, 4), tieredEntry{view: log(t2, 1), delta: 1}, log(t1, 5)},
+	}
+
+	for _, c := range cases {
+		is.Equal(c.want, mergeLog(c.remote, c.snapshot), c.name)
+	}
+}
@@ -0,0 +1,64 @@
+package xratelimit
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name to create a RateLimit's Tracer with
+//
+//	rl := New(store, RateLimitConfig{Limit: 10, Duration: time.Second, Tracer: otel.Tracer(TracerName)})
+const TracerName = "github.com/Mayowa-Ojo/x-ratelimit"
+
+const (
+	attrPolicy     = attribute.Key("ratelimit.policy")
+	attrCost       = attribute.Key("ratelimit.cost")
+	attrAllowed    = attribute.Key("ratelimit.allowed")
+	attrLimit      = attribute.Key("ratelimit.limit")
+	attrRemaining  = attribute.Key("ratelimit.remaining")
+	attrRetryAfter = attribute.Key("ratelimit.retry_after_ms")
+	attrStore      = attribute.Key("ratelimit.store")
+)
+
+// startSpan starts a span when tracing is enabled, the span is nil otherwise
+func (rl *RateLimit) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if rl.Tracer == nil {
+		return ctx, nil
+	}
+
+	return rl.Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endConsumeSpan records the decision on span, rejections as an event
+func endConsumeSpan(span trace.Span, res *Result, err error) {
+	defer span.End()
+
+	if res != nil {
+		span.SetAttributes(
+			attrAllowed.Bool(res.Allowed),
+			attrLimit.Int(res.Limit),
+			attrRemaining.Int(res.Remaining),
+		)
+	}
+
+	switch {
+	case errors.Is(err, ErrRateLimitExceeded):
+		var attrs []attribute.KeyValue
+		if res != nil {
+			attrs = append(attrs, attrRetryAfter.Int64(res.RetryAfter.Milliseconds()))
+		}
+
+		span.AddEvent("rate limit exceeded", trace.WithAttributes(attrs...))
+	case err != nil:
+		endSpanError(span, err)
+	}
+}
+
+func endSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
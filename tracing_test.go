@@ -0,0 +1,89 @@
+package xratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type failingStore struct {
+	Store
+}
+
+func (fs failingStore) GetItem(ctx context.Context, key string) (*RequestLog, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func spanAttrs(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	is := require.New(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	rl := New(NewMemoryStore(), RateLimitConfig{
+		Name:     "api",
+		Duration: time.Second * 60,
+		Limit:    1,
+		Tracer:   provider.Tracer(TracerName),
+	})
+
+	handler := NewMiddlewareStd(rl, WithIpAddressStd("tracing-ip")).Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	spans := exporter.GetSpans()
+	is.Len(spans, 4)
+
+	// store spans end first and are children of the Consume span
+	store, consume := spans[0], spans[1]
+	is.Equal("ratelimit.store.update", store.Name)
+	is.Equal(StoreMemory, spanAttrs(store)[attrStore].AsString())
+	is.Equal("ratelimit.Consume", consume.Name)
+	is.Equal(consume.SpanContext.SpanID(), store.Parent.SpanID())
+
+	attrs := spanAttrs(consume)
+	is.Equal("api", attrs[attrPolicy].AsString())
+	is.True(attrs[attrAllowed].AsBool())
+	is.Equal(int64(0), attrs[attrRemaining].AsInt64())
+	is.Empty(consume.Events)
+
+	rejected := spans[3]
+	is.False(spanAttrs(rejected)[attrAllowed].AsBool())
+	is.Len(rejected.Events, 1)
+	is.Equal("rate limit exceeded", rejected.Events[0].Name)
+	is.Equal(codes.Unset, rejected.Status.Code)
+
+	exporter.Reset()
+
+	rl = New(failingStore{NewMemoryStore()}, RateLimitConfig{Duration: time.Second, Limit: 1, Tracer: provider.Tracer(TracerName)})
+
+	_, err := rl.Consume(context.Background(), "tracing-key")
+	is.Error(err)
+
+	spans = exporter.GetSpans()
+	is.Len(spans, 2)
+	is.Equal("custom", spanAttrs(spans[0])[attrStore].AsString())
+	is.Equal(codes.Error, spans[0].Status.Code)
+	is.Equal(codes.Error, spans[1].Status.Code)
+}